@@ -21,6 +21,9 @@ limitations under the License.
 package fluent
 
 import (
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
@@ -30,6 +33,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 const (
@@ -46,6 +51,9 @@ type Config struct {
 	Timeout   time.Duration
 	RetryWait int
 	MaxRetry  int
+	Compress  string
+	TLSConfig *tls.Config
+	Auth      *AuthConfig
 }
 
 type Fluent struct {
@@ -87,12 +95,8 @@ func New(config Config) (f *Fluent, err error) {
 
 // Close closes the connection.
 func (f *Fluent) Close() (err error) {
-	if f.conn != nil {
-		f.mu.Lock()
-		defer f.mu.Unlock()
-	} else {
-		return
-	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.conn != nil {
 		f.conn.Close()
 		f.conn = nil
@@ -125,6 +129,8 @@ func (f *Fluent) IsReconnecting() bool {
 }
 
 func (f *Fluent) Alive() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	return f.conn != nil
 }
 
@@ -151,11 +157,35 @@ func (f *Fluent) connect() (err error) {
 	}
 	resolved := fmt.Sprintf(format, addr, port)
 	log.Printf("[info] Connect to %s (%s)", f.Server, resolved)
-	f.conn, err = net.DialTimeout("tcp", resolved, f.Config.Timeout)
+	conn, err := net.DialTimeout("tcp", resolved, f.Config.Timeout)
+	if err == nil && f.Config.TLSConfig != nil {
+		conn, err = tlsHandshake(conn, f.Config.TLSConfig, f.Config.Timeout)
+	}
+	if err == nil && f.Config.Auth != nil {
+		if err = ClientHandshake(conn, f.Config.Auth, f.Config.Timeout); err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	f.mu.Lock()
+	f.conn = conn
 	f.recordError(err)
+	f.mu.Unlock()
 	return
 }
 
+func tlsHandshake(conn net.Conn, config *tls.Config, timeout time.Duration) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %s", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// recordError records the error. f.mu must be locked.
 func (f *Fluent) recordError(err error) {
 	f.lastErrorAt = time.Now()
 	f.lastError = err
@@ -203,8 +233,9 @@ func (f *Fluent) RefreshConnection() error {
 }
 
 func (f *Fluent) Send(buffer []byte) (err error) {
-	if f.conn == nil {
-		f.mu.Lock()
+	f.mu.Lock()
+	conn := f.conn
+	if conn == nil {
 		defer f.mu.Unlock()
 		if !f.reconnecting {
 			f.reconnecting = true
@@ -213,18 +244,64 @@ func (f *Fluent) Send(buffer []byte) (err error) {
 		err = errors.New("Can't send messages, client is reconnecting")
 		f.recordError(err)
 		return
-	} else {
-		_, err = f.conn.Write(buffer)
-		if err != nil {
-			f.recordError(err)
-			f.Close()
-		}
-		f.Sent++
 	}
+	f.mu.Unlock()
+
+	_, err = conn.Write(buffer)
+	if err != nil {
+		f.mu.Lock()
+		f.recordError(err)
+		f.mu.Unlock()
+		f.Close()
+	}
+	f.Sent++
 	return
 }
 
+// ReceiveAck waits for an ack response of the chunk from the server.
+func (f *Fluent) ReceiveAck(chunk string, timeout time.Duration) error {
+	f.mu.Lock()
+	conn := f.conn
+	f.mu.Unlock()
+	if conn == nil {
+		return errors.New("Can't receive ack, client is reconnecting")
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	res := make(map[string]interface{})
+	err := codec.NewDecoder(conn, &mh).Decode(&res)
+	if err == nil {
+		var ack string
+		switch v := res["ack"].(type) {
+		case string:
+			ack = v
+		case []byte:
+			ack = string(v)
+		}
+		if ack != chunk {
+			err = fmt.Errorf("Unexpected ack response %s (expected %s)", ack, chunk)
+		}
+	}
+	if err != nil {
+		// a state of the stream is unknown. discard the connection.
+		f.mu.Lock()
+		f.recordError(err)
+		f.mu.Unlock()
+		f.Close()
+	}
+	return err
+}
+
+// NewChunkID returns a new unique chunk id for ack response.
+func NewChunkID() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
 func (f *Fluent) LastErrorString() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if f.lastError != nil {
 		return fmt.Sprintf("[%s] %s", f.lastErrorAt, f.lastError)
 	} else {
@@ -0,0 +1,174 @@
+package fluent
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+// AuthConfig is settings of the handshake (HELO/PING/PONG) in forward protocol v1.
+type AuthConfig struct {
+	SharedKey    string
+	Username     string
+	Password     string
+	SelfHostname string
+}
+
+func newSalt() []byte {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b
+}
+
+func digest(values ...[]byte) string {
+	h := sha512.New()
+	for _, v := range values {
+		h.Write(v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// secureEqual compares values in constant time to prevent timing attacks.
+func secureEqual(got []byte, expected string) bool {
+	return subtle.ConstantTimeCompare(got, []byte(expected)) == 1
+}
+
+func toBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
+
+func readMessage(conn net.Conn, name string, n int) ([]interface{}, error) {
+	v := make([]interface{}, 0, n)
+	if err := codec.NewDecoder(conn, &mh).Decode(&v); err != nil {
+		return nil, err
+	}
+	if len(v) < n || string(toBytes(v[0])) != name {
+		return nil, fmt.Errorf("Unexpected handshake message (expected %s)", name)
+	}
+	return v, nil
+}
+
+// ClientHandshake performs the client side of the handshake.
+func ClientHandshake(conn net.Conn, config *AuthConfig, timeout time.Duration) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// ["HELO", {"nonce": nonce, "auth": auth_salt, "keepalive": true}]
+	helo, err := readMessage(conn, "HELO", 2)
+	if err != nil {
+		return err
+	}
+	options, ok := helo[1].(map[string]interface{})
+	if !ok {
+		return errors.New("Failed to decode HELO options")
+	}
+	nonce := toBytes(options["nonce"])
+	authSalt := toBytes(options["auth"])
+
+	// ["PING", self_hostname, shared_key_salt, sha512_hex(shared_key_salt + self_hostname + nonce + shared_key), username, sha512_hex(auth_salt + username + password)]
+	sharedKeySalt := newSalt()
+	var passwordDigest string
+	if len(authSalt) > 0 {
+		passwordDigest = digest(authSalt, []byte(config.Username), []byte(config.Password))
+	}
+	ping := []interface{}{
+		"PING",
+		config.SelfHostname,
+		sharedKeySalt,
+		digest(sharedKeySalt, []byte(config.SelfHostname), nonce, []byte(config.SharedKey)),
+		config.Username,
+		passwordDigest,
+	}
+	if err := writeMsgpack(conn, ping); err != nil {
+		return err
+	}
+
+	// ["PONG", auth_result, reason, server_hostname, sha512_hex(shared_key_salt + server_hostname + nonce + shared_key)]
+	pong, err := readMessage(conn, "PONG", 5)
+	if err != nil {
+		return err
+	}
+	if result, _ := pong[1].(bool); !result {
+		return fmt.Errorf("Authentication failed: %s", toBytes(pong[2]))
+	}
+	serverHostname := toBytes(pong[3])
+	if !secureEqual(toBytes(pong[4]), digest(sharedKeySalt, serverHostname, nonce, []byte(config.SharedKey))) {
+		return errors.New("Authentication failed: shared key mismatch in PONG")
+	}
+	return nil
+}
+
+// ServerHandshake performs the server side of the handshake, and returns an error when the client was not authenticated.
+func ServerHandshake(conn net.Conn, config *AuthConfig, timeout time.Duration) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := newSalt()
+	var authSalt []byte
+	if config.Username != "" {
+		authSalt = newSalt()
+	} else {
+		authSalt = []byte{}
+	}
+	helo := []interface{}{
+		"HELO",
+		map[string]interface{}{
+			"nonce":     nonce,
+			"auth":      authSalt,
+			"keepalive": true,
+		},
+	}
+	if err := writeMsgpack(conn, helo); err != nil {
+		return err
+	}
+
+	ping, err := readMessage(conn, "PING", 6)
+	if err != nil {
+		return err
+	}
+	clientHostname := toBytes(ping[1])
+	sharedKeySalt := toBytes(ping[2])
+
+	var reason string
+	if !secureEqual(toBytes(ping[3]), digest(sharedKeySalt, clientHostname, nonce, []byte(config.SharedKey))) {
+		reason = "shared_key mismatch"
+	} else if config.Username != "" {
+		if !secureEqual(toBytes(ping[4]), config.Username) ||
+			!secureEqual(toBytes(ping[5]), digest(authSalt, []byte(config.Username), []byte(config.Password))) {
+			reason = "username/password mismatch"
+		}
+	}
+
+	var pong []interface{}
+	if reason != "" {
+		pong = []interface{}{"PONG", false, reason, "", ""}
+	} else {
+		pong = []interface{}{
+			"PONG",
+			true,
+			"",
+			config.SelfHostname,
+			digest(sharedKeySalt, []byte(config.SelfHostname), nonce, []byte(config.SharedKey)),
+		}
+	}
+	if err := writeMsgpack(conn, pong); err != nil {
+		return err
+	}
+	if reason != "" {
+		return fmt.Errorf("Authentication failed: %s from %s", reason, clientHostname)
+	}
+	return nil
+}
@@ -20,6 +20,7 @@ const (
 	mpStr16              = 0xda
 	mpStr32              = 0xdb
 	mp2ElmArray          = 0x92
+	mp3ElmArray          = 0x93
 	mp1ElmMap            = 0x81
 	mpBytes8             = 0xc4
 	mpBytes16            = 0xc5
@@ -127,17 +128,37 @@ func toMsgpackRecord(ts time.Time, data map[string]interface{}) ([]byte, error)
 	return b.Bytes(), nil
 }
 
-func toMsgpackRecordSet(tag string, bin []byte) []byte {
+func toMsgpackRecordSet(tag string, bin []byte, option map[string]interface{}) ([]byte, error) {
+	return toMsgpackRecordSetWithHead(tag, bin, option, (*msgpackBuffer).WriteMpStringHead)
+}
+
+func toMsgpackCompressedRecordSet(tag string, bin []byte, option map[string]interface{}) ([]byte, error) {
+	// compressed entries are not a valid string. pack as binary
+	return toMsgpackRecordSetWithHead(tag, bin, option, (*msgpackBuffer).WriteMpBytesHead)
+}
+
+func toMsgpackRecordSetWithHead(tag string, bin []byte, option map[string]interface{}, writeHead func(*msgpackBuffer, int)) ([]byte, error) {
 	b := new(msgpackBuffer)
 	// required capacity
 	b.Grow(len(tag) + len(bin) + 16)
-	// 2 elments array [tag, bin]
-	b.WriteByte(mp2ElmArray)
+	if option == nil {
+		// 2 elments array [tag, bin]
+		b.WriteByte(mp2ElmArray)
+	} else {
+		// 3 elments array [tag, bin, option]
+		b.WriteByte(mp3ElmArray)
+	}
 	// tag
 	b.WriteMpStringHead(len(tag))
 	b.WriteString(tag)
 	// buf
-	b.WriteMpStringHead(len(bin))
+	writeHead(b, len(bin))
 	b.Write(bin)
-	return b.Bytes()
+	// option
+	if option != nil {
+		if err := writeMsgpack(b, option); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
 }
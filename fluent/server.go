@@ -26,6 +26,7 @@ package fluent
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -150,7 +151,54 @@ type FluentRecordSet struct {
 	Records []FluentRecordType
 }
 
+// ForwardOption is an option of forward protocol messages.
+type ForwardOption struct {
+	Chunk      string
+	Size       int
+	Compressed string
+}
+
+const (
+	CompressGzip = "gzip"
+)
+
+func (o *ForwardOption) toMap() map[string]interface{} {
+	if o == nil {
+		return nil
+	}
+	m := make(map[string]interface{})
+	if o.Chunk != "" {
+		m["chunk"] = o.Chunk
+	}
+	if o.Size > 0 {
+		m["size"] = o.Size
+	}
+	if o.Compressed != "" {
+		m["compressed"] = o.Compressed
+	}
+	return m
+}
+
 func (rs *FluentRecordSet) PackAsPackedForward() ([]byte, error) {
+	return rs.PackAsPackedForwardWithOption(nil)
+}
+
+// PackAsPackedForwardWithOption packs records as PackedForward mode with the option.
+func (rs *FluentRecordSet) PackAsPackedForwardWithOption(option *ForwardOption) ([]byte, error) {
+	entries, err := rs.PackEntries()
+	if err != nil {
+		return nil, err
+	}
+	if option != nil && option.Size == 0 {
+		o := *option
+		o.Size = len(rs.Records)
+		option = &o
+	}
+	return PackPackedForward(rs.Tag, entries, option)
+}
+
+// PackEntries packs records as an entries stream of PackedForward mode.
+func (rs *FluentRecordSet) PackEntries() ([]byte, error) {
 	buffer := make([]byte, 0)
 	for _, record := range rs.Records {
 		data, err := record.Pack()
@@ -159,7 +207,30 @@ func (rs *FluentRecordSet) PackAsPackedForward() ([]byte, error) {
 		}
 		buffer = append(buffer, data...)
 	}
-	return toMsgpackRecordSet(rs.Tag, buffer), nil
+	return buffer, nil
+}
+
+// PackPackedForward packs the entries stream as PackedForward mode.
+// When option.Compressed is "gzip", the entries are compressed (CompressedPackedForward mode).
+func PackPackedForward(tag string, entries []byte, option *ForwardOption) ([]byte, error) {
+	if option != nil {
+		switch option.Compressed {
+		case "":
+		case CompressGzip:
+			var b bytes.Buffer
+			zw := gzip.NewWriter(&b)
+			if _, err := zw.Write(entries); err != nil {
+				return nil, err
+			}
+			if err := zw.Close(); err != nil {
+				return nil, err
+			}
+			return toMsgpackCompressedRecordSet(tag, b.Bytes(), option.toMap())
+		default:
+			return nil, fmt.Errorf("Unsupported compression %s", option.Compressed)
+		}
+	}
+	return toMsgpackRecordSet(tag, entries, option.toMap())
 }
 
 func (rs *FluentRecordSet) PackAsForward() ([]byte, error) {
@@ -206,6 +277,33 @@ func coerceInPlace(data map[string]interface{}) {
 	}
 }
 
+// DecodeMsgpackRecords decodes a msgpack map or an array of maps into records.
+func DecodeMsgpackRecords(b []byte) ([]map[string]interface{}, error) {
+	var v interface{}
+	if err := codec.NewDecoderBytes(b, &mh).Decode(&v); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		values = []interface{}{v}
+	case []interface{}:
+		values = v
+	default:
+		return nil, errors.New("msgpack must be a map or an array of maps")
+	}
+	records := make([]map[string]interface{}, len(values))
+	for i, value := range values {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("msgpack must be a map or an array of maps")
+		}
+		coerceInPlace(data)
+		records[i] = data
+	}
+	return records, nil
+}
+
 func decodeRecordSet(tag []byte, entries []interface{}) (FluentRecordSet, error) {
 	records := make([]FluentRecordType, len(entries))
 	for i, _entry := range entries {
@@ -241,24 +339,34 @@ func decodeRecordSet(tag []byte, entries []interface{}) (FluentRecordSet, error)
 }
 
 func DecodeEntries(conn net.Conn) ([]FluentRecordSet, error) {
+	recordSets, _, err := DecodeEntriesWithOption(conn)
+	return recordSets, err
+}
+
+// DecodeEntriesWithOption decodes a forward protocol message and its option.
+func DecodeEntriesWithOption(conn net.Conn) ([]FluentRecordSet, *ForwardOption, error) {
 	dec := codec.NewDecoder(conn, &mh)
 	v := []interface{}{nil, nil, nil}
 	err := dec.Decode(&v)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	tag, ok := v[0].([]byte)
 	if !ok {
-		return nil, errors.New("Failed to decode tag field")
+		return nil, nil, errors.New("Failed to decode tag field")
 	}
-
+	var option *ForwardOption
 	var retval []FluentRecordSet
 	switch timestamp_or_entries := v[1].(type) {
 	case int, uint, int64, uint64, int32, uint32, float32, float64:
+		option, err = decodeOption(v, 3)
+		if err != nil {
+			return nil, nil, err
+		}
 		timestamp := toInt64(timestamp_or_entries)
 		data, ok := v[2].(map[string]interface{})
 		if !ok {
-			return nil, errors.New("Failed to decode data field")
+			return nil, nil, errors.New("Failed to decode data field")
 		}
 		coerceInPlace(data)
 		retval = []FluentRecordSet{
@@ -273,10 +381,14 @@ func DecodeEntries(conn net.Conn) ([]FluentRecordSet, error) {
 			},
 		}
 	case time.Time:
+		option, err = decodeOption(v, 3)
+		if err != nil {
+			return nil, nil, err
+		}
 		timestamp := timestamp_or_entries
 		data, ok := v[2].(map[string]interface{})
 		if !ok {
-			return nil, errors.New("Failed to decode data field")
+			return nil, nil, errors.New("Failed to decode data field")
 		}
 		coerceInPlace(data)
 		retval = []FluentRecordSet{
@@ -292,15 +404,37 @@ func DecodeEntries(conn net.Conn) ([]FluentRecordSet, error) {
 		}
 	case []interface{}: // Forward
 		if !ok {
-			return nil, errors.New("Unexpected payload format")
+			return nil, nil, errors.New("Unexpected payload format")
+		}
+		option, err = decodeOption(v, 2)
+		if err != nil {
+			return nil, nil, err
 		}
 		recordSet, err := decodeRecordSet(tag, timestamp_or_entries)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		retval = []FluentRecordSet{recordSet}
 	case []byte: // PackedForward
-		reader := bytes.NewReader(timestamp_or_entries)
+		option, err = decodeOption(v, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		var reader io.Reader = bytes.NewReader(timestamp_or_entries)
+		if option != nil {
+			switch option.Compressed {
+			case "":
+			case CompressGzip: // CompressedPackedForward
+				zr, err := gzip.NewReader(reader)
+				if err != nil {
+					return nil, nil, err
+				}
+				defer zr.Close()
+				reader = zr
+			default:
+				return nil, nil, fmt.Errorf("Unsupported compression %s", option.Compressed)
+			}
+		}
 		entries := make([]interface{}, 0)
 		for {
 			entry := make([]interface{}, 0)
@@ -308,19 +442,51 @@ func DecodeEntries(conn net.Conn) ([]FluentRecordSet, error) {
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				return nil, errors.New("Unexpected payload format")
+				return nil, nil, errors.New("Unexpected payload format")
 			}
 			entries = append(entries, entry)
 		}
 		recordSet, err := decodeRecordSet(tag, entries)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		retval = []FluentRecordSet{recordSet}
 	default:
-		return nil, errors.New(fmt.Sprintf("Unknown type: %t", timestamp_or_entries))
+		return nil, nil, errors.New(fmt.Sprintf("Unknown type: %t", timestamp_or_entries))
+	}
+	return retval, option, nil
+}
+
+func decodeOption(v []interface{}, i int) (*ForwardOption, error) {
+	if len(v) <= i || v[i] == nil {
+		return nil, nil
 	}
-	return retval, nil
+	m, ok := v[i].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Failed to decode option field")
+	}
+	option := &ForwardOption{}
+	switch chunk := m["chunk"].(type) {
+	case []byte:
+		option.Chunk = string(chunk)
+	case string:
+		option.Chunk = chunk
+	}
+	if size, ok := m["size"]; ok {
+		option.Size = int(toInt64(size))
+	}
+	switch compressed := m["compressed"].(type) {
+	case []byte:
+		option.Compressed = string(compressed)
+	case string:
+		option.Compressed = compressed
+	}
+	return option, nil
+}
+
+// WriteAck writes an ack response of the chunk.
+func WriteAck(w io.Writer, chunk string) error {
+	return writeMsgpack(w, map[string]string{"ack": chunk})
 }
 
 func toInt64(v interface{}) int64 {
@@ -1,6 +1,7 @@
 package fluent_test
 
 import (
+	"net"
 	"testing"
 	"time"
 
@@ -25,3 +26,71 @@ func TestPack(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDecodeEntriesWithOption(t *testing.T) {
+	recordSet := &fluent.FluentRecordSet{
+		Tag: "test",
+		Records: []fluent.FluentRecordType{
+			&fluent.TinyFluentRecord{
+				Timestamp: time.Now(),
+				Data:      map[string]interface{}{"message": "text"},
+			},
+		},
+	}
+	packed, err := recordSet.PackAsPackedForwardWithOption(&fluent.ForwardOption{Chunk: "xxx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(packed)
+		client.Close()
+	}()
+	recordSets, option, err := fluent.DecodeEntriesWithOption(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordSets) != 1 || recordSets[0].Tag != "test" || len(recordSets[0].Records) != 1 {
+		t.Errorf("unexpected record sets %#v", recordSets)
+	}
+	if option == nil || option.Chunk != "xxx" || option.Size != 1 {
+		t.Errorf("unexpected option %#v", option)
+	}
+}
+
+func TestDecodeEntriesCompressed(t *testing.T) {
+	records := make([]fluent.FluentRecordType, 0)
+	for i := 0; i < 100; i++ {
+		records = append(records, &fluent.TinyFluentMessage{
+			Timestamp: time.Now(),
+			FieldName: "message",
+			Message:   []byte("message message message message message"),
+		})
+	}
+	recordSet := &fluent.FluentRecordSet{Tag: "test", Records: records}
+	raw, _ := recordSet.PackAsPackedForward()
+	packed, err := recordSet.PackAsPackedForwardWithOption(&fluent.ForwardOption{Compressed: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed) >= len(raw) {
+		t.Errorf("compressed %d bytes must be smaller than raw %d bytes", len(packed), len(raw))
+	}
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(packed)
+		client.Close()
+	}()
+	recordSets, option, err := fluent.DecodeEntriesWithOption(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordSets) != 1 || len(recordSets[0].Records) != 100 {
+		t.Errorf("unexpected record sets %#v", recordSets)
+	}
+	if option == nil || option.Compressed != "gzip" {
+		t.Errorf("unexpected option %#v", option)
+	}
+}
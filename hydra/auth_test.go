@@ -0,0 +1,100 @@
+package hydra_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func runAuthReceiver(t *testing.T, auth hydra.ConfigAuth) (*hydra.Context, *hydra.InForward) {
+	config := &hydra.ConfigReceiver{
+		Host:              "127.0.0.1",
+		Port:              0,
+		MaxBufferMessages: 1000,
+		ConfigAuth:        auth,
+	}
+	c := hydra.NewContext()
+	inForward, err := hydra.NewInForward(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inForward)
+	return c, inForward
+}
+
+func TestAuthForward(t *testing.T) {
+	rc, inForward := runAuthReceiver(t, hydra.ConfigAuth{
+		SharedKey:    "secret",
+		Username:     "hydra",
+		Password:     "pass",
+		SelfHostname: "receiver",
+	})
+
+	configServer := newConfigServer(inForward.Addr.String())
+	configServer.ConfigAuth = hydra.ConfigAuth{
+		SharedKey:    "secret",
+		Username:     "hydra",
+		Password:     "pass",
+		SelfHostname: "sender",
+	}
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{configServer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(outForward)
+	c.MessageCh <- prepareRecordSet()
+
+	if n := countMessages(rc.MessageCh, time.Second); n != len(TestMessageLines) {
+		t.Errorf("arrived messages %d expected %d", n, len(TestMessageLines))
+	}
+	c.Shutdown()
+}
+
+func TestAuthRejected(t *testing.T) {
+	rc, inForward := runAuthReceiver(t, hydra.ConfigAuth{
+		SharedKey:    "secret",
+		Username:     "hydra",
+		Password:     "pass",
+		SelfHostname: "receiver",
+	})
+
+	for _, auth := range []*fluent.AuthConfig{
+		{SharedKey: "wrong", Username: "hydra", Password: "pass", SelfHostname: "sender"},
+		{SharedKey: "secret", Username: "hydra", Password: "wrong", SelfHostname: "sender"},
+	} {
+		logger, err := fluent.New(fluent.Config{
+			Server: inForward.Addr.String(),
+			Auth:   auth,
+		})
+		if err == nil {
+			t.Error("handshake must be failed")
+		}
+		if logger.Alive() {
+			t.Error("logger must not be alive")
+		}
+		if e := logger.LastErrorString(); !strings.Contains(e, "Authentication failed") {
+			t.Errorf("unexpected last error %s", e)
+		}
+	}
+
+	rejected := int64(0)
+	timeout := time.After(1 * time.Second)
+RECEIVE:
+	for {
+		select {
+		case s := <-rc.MonitorCh:
+			if rs, ok := s.(*hydra.ReceiverStat); ok {
+				rejected += rs.Rejected
+			}
+		case <-timeout:
+			break RECEIVE
+		}
+	}
+	if rejected != 2 {
+		t.Errorf("rejected %d expected %d", rejected, 2)
+	}
+}
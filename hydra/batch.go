@@ -0,0 +1,104 @@
+package hydra
+
+import (
+	"time"
+)
+
+const (
+	DefaultChunkMaxRecords = 10000
+	DefaultChunkMaxBytes   = 8 * 1024 * 1024
+)
+
+// reasons of flushing a batch
+const (
+	FlushByInterval = "interval"
+	FlushByRecords  = "records"
+	FlushByBytes    = "bytes"
+	FlushByShutdown = "shutdown"
+)
+
+// Batch is packed entries which were coalesced for a tag.
+type Batch struct {
+	Tag       string
+	Entries   []byte
+	Messages  int
+	Reason    string
+	createdAt time.Time
+}
+
+// Batcher coalesces packed entries per tag into batches bounded by flush interval, records and bytes.
+type Batcher struct {
+	flushInterval time.Duration
+	maxRecords    int
+	maxBytes      int
+	batches       map[string]*Batch
+}
+
+func NewBatcher(flushInterval time.Duration, maxRecords, maxBytes int) *Batcher {
+	if maxRecords == 0 {
+		maxRecords = DefaultChunkMaxRecords
+	}
+	if maxBytes == 0 {
+		maxBytes = DefaultChunkMaxBytes
+	}
+	return &Batcher{
+		flushInterval: flushInterval,
+		maxRecords:    maxRecords,
+		maxBytes:      maxBytes,
+		batches:       make(map[string]*Batch),
+	}
+}
+
+// Add appends entries to the batch of the tag, and returns batches which reached the limits.
+func (b *Batcher) Add(tag string, entries []byte, messages int) []*Batch {
+	flushed := make([]*Batch, 0)
+	batch, ok := b.batches[tag]
+	if ok && len(batch.Entries)+len(entries) > b.maxBytes {
+		flushed = append(flushed, b.take(tag, FlushByBytes))
+		ok = false
+	}
+	if !ok {
+		batch = &Batch{
+			Tag:       tag,
+			Entries:   make([]byte, 0, len(entries)),
+			createdAt: time.Now(),
+		}
+		b.batches[tag] = batch
+	}
+	batch.Entries = append(batch.Entries, entries...)
+	batch.Messages += messages
+	switch {
+	case batch.Messages >= b.maxRecords:
+		flushed = append(flushed, b.take(tag, FlushByRecords))
+	case len(batch.Entries) >= b.maxBytes:
+		flushed = append(flushed, b.take(tag, FlushByBytes))
+	}
+	return flushed
+}
+
+// Expired returns batches which were created before flush interval.
+func (b *Batcher) Expired(now time.Time) []*Batch {
+	flushed := make([]*Batch, 0)
+	for tag, batch := range b.batches {
+		if now.Sub(batch.createdAt) >= b.flushInterval {
+			flushed = append(flushed, b.take(tag, FlushByInterval))
+		}
+	}
+	return flushed
+}
+
+// FlushAll returns all pending batches.
+func (b *Batcher) FlushAll() []*Batch {
+	flushed := make([]*Batch, 0, len(b.batches))
+	for tag := range b.batches {
+		flushed = append(flushed, b.take(tag, FlushByShutdown))
+	}
+	return flushed
+}
+
+func (b *Batcher) take(tag string, reason string) *Batch {
+	batch := b.batches[tag]
+	delete(b.batches, tag)
+	batch.Reason = reason
+	return batch
+}
@@ -0,0 +1,92 @@
+package hydra_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestBatcherLimits(t *testing.T) {
+	b := hydra.NewBatcher(time.Second, 5, 20)
+	if batches := b.Add("test.a", []byte("0123456789"), 2); len(batches) != 0 {
+		t.Errorf("unexpected flushed %#v", batches)
+	}
+	// reached ChunkMaxRecords
+	batches := b.Add("test.a", []byte("01234"), 3)
+	if len(batches) != 1 || batches[0].Reason != hydra.FlushByRecords || batches[0].Messages != 5 || string(batches[0].Entries) != "012345678901234" {
+		t.Errorf("unexpected flushed %#v", batches)
+	}
+
+	// exceeds ChunkMaxBytes. the pending batch is flushed before appended
+	b.Add("test.b", []byte("0123456789"), 1)
+	batches = b.Add("test.b", []byte("01234567890123456789"), 1)
+	if len(batches) != 2 || batches[0].Reason != hydra.FlushByBytes || string(batches[0].Entries) != "0123456789" ||
+		batches[1].Reason != hydra.FlushByBytes || len(batches[1].Entries) != 20 {
+		t.Errorf("unexpected flushed %#v", batches)
+	}
+}
+
+func TestBatcherExpired(t *testing.T) {
+	b := hydra.NewBatcher(500*time.Millisecond, 0, 0)
+	b.Add("test.a", []byte("a"), 1)
+	if batches := b.Expired(time.Now()); len(batches) != 0 {
+		t.Errorf("unexpected flushed %#v", batches)
+	}
+	b.Add("test.a", []byte("a"), 1)
+	b.Add("test.b", []byte("b"), 1)
+	batches := b.Expired(time.Now().Add(time.Second))
+	if len(batches) != 2 {
+		t.Errorf("unexpected flushed %#v", batches)
+	}
+	for _, batch := range batches {
+		if batch.Reason != hydra.FlushByInterval {
+			t.Errorf("unexpected reason %s", batch.Reason)
+		}
+	}
+	if batches := b.FlushAll(); len(batches) != 0 {
+		t.Errorf("unexpected flushed %#v", batches)
+	}
+}
+
+func TestForwardBatched(t *testing.T) {
+	rc, inForward := runAuthReceiver(t, hydra.ConfigAuth{})
+
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{newConfigServer(inForward.Addr.String())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outForward.FlushInterval = 500 * time.Millisecond
+	c.RunProcess(outForward)
+	for i := 0; i < 10; i++ {
+		c.MessageCh <- prepareRecordSet()
+	}
+
+	if n := countMessages(rc.MessageCh, time.Second); n != len(TestMessageLines)*10 {
+		t.Errorf("arrived messages %d expected %d", n, len(TestMessageLines)*10)
+	}
+	stat := &hydra.SentStat{Tag: TestTag}
+	stats := &hydra.Stats{Sent: map[string]*hydra.SentStat{TestTag: stat}}
+RECEIVE:
+	for {
+		select {
+		case s := <-c.MonitorCh:
+			if s, ok := s.(*hydra.SentStat); ok {
+				s.ApplyTo(stats)
+			}
+		default:
+			break RECEIVE
+		}
+	}
+	if stat.Sents != 1 || stat.Messages != int64(len(TestMessageLines)*10) {
+		t.Errorf("record sets must be sent as a chunk %#v", stat)
+	}
+	if stat.FlushReasons[hydra.FlushByInterval] != 1 {
+		t.Errorf("unexpected flush reasons %#v", stat.FlushReasons)
+	}
+	if stat.AvgChunkBytes != float64(stat.Bytes) {
+		t.Errorf("unexpected avg chunk bytes %f", stat.AvgChunkBytes)
+	}
+	c.Shutdown()
+}
@@ -4,17 +4,22 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
 )
 
 const (
-	DefaultFluentdPort       = 24224
-	DefaultFieldName         = "message"
-	DefaultMaxBufferMessages = 1024 * 1024
-	DefaultTimeKey           = "time"
+	DefaultFluentdPort        = 24224
+	DefaultFieldName          = "message"
+	DefaultMaxBufferMessages  = 1024 * 1024
+	DefaultTimeKey            = "time"
+	DefaultAckResponseTimeout = 30 * time.Second
 )
 
 var DefaultTimeFormat = TimeFormat(time.RFC3339)
@@ -29,11 +34,35 @@ type Config struct {
 	Receiver         *ConfigReceiver
 	Monitor          *ConfigMonitor
 	SubSecondTime    bool
+
+	RequireAckResponse bool
+	AckResponseTimeout Duration
+	SelfHostname       string
+	Buffer             *ConfigBuffer
+	FlushInterval      Duration
+	ChunkMaxRecords    int
+	ChunkMaxBytes      int
+	Heartbeat          *ConfigHeartbeat
+	Syslogs            []*ConfigSyslog `toml:"Syslog"`
+	HTTP               *ConfigHTTP
+	Execs              []*ConfigExec `toml:"Exec"`
+	GrokPatternFiles   []string
+	Matches            []*ConfigMatch `toml:"Match"`
+	Outputs            []*ConfigOutput
 }
 
+// ConfigServer is settings of a forwarding server.
+// Servers which have the same Output are grouped into an out_forward (default "default").
+// Output is a shorthand of ConfigOutput which has the top level ServerRoundRobin and Buffer.
 type ConfigServer struct {
-	Host string
-	Port int
+	Host     string
+	Port     int
+	Compress string
+	Weight   int
+	Standby  bool
+	Output   string
+	ConfigTLS
+	ConfigAuth
 }
 
 type ConfigLogfile struct {
@@ -42,16 +71,269 @@ type ConfigLogfile struct {
 	FieldName  string
 	Format     FileFormat
 	Regexp     *Regexp
+	Keys       []string
+	Delimiter  string
 	ConvertMap ConvertMap `toml:"Types"`
 	TimeParse  bool
 	TimeKey    string
 	TimeFormat TimeFormat
+	PosFile    string
+	RotateWait Duration
+
+	MultilineStart         *Regexp
+	MultilineMaxLines      int
+	MultilineMaxBytes      int
+	MultilineFlushInterval Duration
+
+	ConfigFilters
+}
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 type ConfigReceiver struct {
 	Host              string
 	Port              int
 	MaxBufferMessages int
+	ConfigTLS
+	VerifyClientCert bool
+	ConfigAuth
+	ConfigFilters
+}
+
+// ConfigFilters is settings of filters applied to records of an input.
+// Filters are applied in order of Grep, Transform, Mask and RewriteTag.
+type ConfigFilters struct {
+	Grep       []*ConfigGrep
+	Transform  []*ConfigTransform
+	Mask       []*ConfigMask
+	RewriteTag []*ConfigRewriteTag
+}
+
+// ConfigGrep keeps records which have Key matching Pattern, or drops them when Exclude.
+// TagPattern restricts tags to be filtered (default all).
+type ConfigGrep struct {
+	Name       string
+	TagPattern *TagPattern
+	Key        string
+	Pattern    *Regexp
+	Exclude    bool
+}
+
+// ConfigTransform renames, adds and removes fields of records.
+// Values of AddFields may contain ${hostname}, ${tag} and ${file} (the path of Logs).
+type ConfigTransform struct {
+	Name         string
+	TagPattern   *TagPattern
+	RenameFields map[string]string
+	AddFields    map[string]string
+	RemoveFields []string
+	hostname     string
+}
+
+// ConfigMask masks PII detected by Detectors (default all built-in) and Patterns in Fields of records.
+// Without Fields, all fields (and messages of Format None) are masked.
+// Method "redact" (default) replaces PII with Replacement, "hmac" with HMAC-SHA256 hex digests by HMACKey.
+type ConfigMask struct {
+	Name        string
+	TagPattern  *TagPattern
+	Fields      []string
+	Detectors   []string
+	Patterns    []*Regexp
+	Method      string
+	Replacement string
+	HMACKey     string
+}
+
+// ConfigRewriteTag rewrites tags of records which have Key matching Pattern (or all records without Pattern).
+// Tag may contain ${tag}, ${N}, ${name} (a named submatch of Pattern) and ${field} (a field of the record).
+type ConfigRewriteTag struct {
+	Name       string
+	TagPattern *TagPattern
+	Key        string
+	Pattern    *Regexp
+	Tag        string
+}
+
+// Filters returns filters for records read from the file ("" for other inputs).
+func (cf *ConfigFilters) Filters(file string) (Filters, error) {
+	filters := make(Filters, 0, len(cf.Grep)+len(cf.Transform)+len(cf.Mask)+len(cf.RewriteTag))
+	for _, cg := range cf.Grep {
+		f, err := NewGrepFilter(cg)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	for _, ct := range cf.Transform {
+		f, err := NewTransformFilter(ct, file)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	for _, cm := range cf.Mask {
+		f, err := NewMaskFilter(cm)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	for _, cr := range cf.RewriteTag {
+		f, err := NewRewriteTagFilter(cr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func (cf *ConfigFilters) regexps() []*Regexp {
+	regexps := make([]*Regexp, 0, len(cf.Grep)+len(cf.RewriteTag))
+	for _, cg := range cf.Grep {
+		regexps = append(regexps, cg.Pattern)
+	}
+	for _, cm := range cf.Mask {
+		regexps = append(regexps, cm.Patterns...)
+	}
+	for _, cr := range cf.RewriteTag {
+		regexps = append(regexps, cr.Pattern)
+	}
+	return regexps
+}
+
+// Restrict names filters by the scope (e.g. the tag of Logs) to be distinguished in stats.
+func (cf *ConfigFilters) Restrict(c *Config, scope string) {
+	for i, cg := range cf.Grep {
+		if cg.Name == "" {
+			cg.Name = fmt.Sprintf("%s grep[%d]", scope, i)
+		}
+	}
+	for i, ct := range cf.Transform {
+		if ct.Name == "" {
+			ct.Name = fmt.Sprintf("%s transform[%d]", scope, i)
+		}
+		ct.hostname = c.SelfHostname
+	}
+	for i, cm := range cf.Mask {
+		if cm.Name == "" {
+			cm.Name = fmt.Sprintf("%s mask[%d]", scope, i)
+		}
+	}
+	for i, cr := range cf.RewriteTag {
+		if cr.Name == "" {
+			cr.Name = fmt.Sprintf("%s rewrite_tag[%d]", scope, i)
+		}
+	}
+}
+
+// ConfigAuth is settings of the forward protocol handshake with a shared key.
+type ConfigAuth struct {
+	SharedKey    string
+	Username     string
+	Password     string
+	SelfHostname string
+}
+
+// AuthConfig returns nil when SharedKey is empty.
+func (ca *ConfigAuth) AuthConfig() *fluent.AuthConfig {
+	if ca.SharedKey == "" {
+		return nil
+	}
+	return &fluent.AuthConfig{
+		SharedKey:    ca.SharedKey,
+		Username:     ca.Username,
+		Password:     ca.Password,
+		SelfHostname: ca.SelfHostname,
+	}
+}
+
+func (ca *ConfigAuth) Restrict(c *Config) {
+	if ca.SelfHostname == "" {
+		ca.SelfHostname = c.SelfHostname
+	}
+}
+
+// ConfigSyslog is settings of a syslog input.
+type ConfigSyslog struct {
+	Tag       string
+	Protocol  string
+	Host      string
+	Port      int
+	Path      string
+	Format    string
+	FieldName string
+}
+
+// ConfigHTTP is settings of the HTTP input.
+type ConfigHTTP struct {
+	Host        string
+	Port        int
+	TagPrefix   string
+	MaxBodySize int64
+}
+
+// ConfigExec is settings of an exec input.
+// The command runs every Interval, or is kept alive when Interval is zero.
+type ConfigExec struct {
+	Tag         string
+	Command     string
+	Interval    Duration
+	RestartWait Duration
+	FieldName   string
+	Format      FileFormat
+	Regexp      *Regexp
+	Keys        []string
+	Delimiter   string
+	ConvertMap  ConvertMap `toml:"Types"`
+	TimeParse   bool
+	TimeKey     string
+	TimeFormat  TimeFormat
+}
+
+// ConfigMatch routes records which have tags matching Pattern to Output.
+// The first matched Match is used. Records matching no Match are sent to the "default" Output.
+// When Copy is true, records are sent to Output and also routed by following Matches.
+type ConfigMatch struct {
+	Pattern *TagPattern
+	Output  string
+	Copy    bool
+}
+
+// ConfigOutput is settings of an out_forward which has its own servers and buffer.
+// Records which have tags matching Match are sent to the output (see ConfigMatch for Copy).
+type ConfigOutput struct {
+	Name             string
+	Match            *TagPattern
+	Copy             bool
+	Servers          []*ConfigServer
+	ServerRoundRobin bool
+	Buffer           *ConfigBuffer
+}
+
+// ConfigHeartbeat is settings of heartbeats to forwarding servers.
+type ConfigHeartbeat struct {
+	Type         string
+	Interval     Duration
+	Detector     string
+	PhiThreshold float64
+	HardTimeout  Duration
+	MaxFailures  int
+}
+
+// ConfigBuffer is settings of the file buffer between inputs and out_forward.
+type ConfigBuffer struct {
+	Path     string
+	MaxBytes int64
+	MaxAge   Duration
 }
 
 type ConfigMonitor struct {
@@ -65,10 +347,49 @@ func ReadConfig(filename string) (*Config, error) {
 	if _, err := toml.DecodeFile(filename, &config); err != nil {
 		return nil, err
 	}
+	if err := config.CompileGrok(); err != nil {
+		return nil, err
+	}
 	config.Restrict()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
+// CompileGrok compiles grok expressions in Regexp with the built-in patterns and GrokPatternFiles.
+func (c *Config) CompileGrok() error {
+	g := NewGrok()
+	for _, file := range c.GrokPatternFiles {
+		if err := g.LoadPatternFile(file); err != nil {
+			return err
+		}
+	}
+	regexps := make([]*Regexp, 0)
+	for _, cl := range c.Logs {
+		regexps = append(regexps, cl.Regexp, cl.MultilineStart)
+		regexps = append(regexps, cl.ConfigFilters.regexps()...)
+	}
+	if c.Receiver != nil {
+		regexps = append(regexps, c.Receiver.ConfigFilters.regexps()...)
+	}
+	for _, ce := range c.Execs {
+		regexps = append(regexps, ce.Regexp)
+	}
+	for _, r := range regexps {
+		if err := g.CompileRegexp(r); err != nil {
+			return err
+		}
+	}
+	for _, cl := range c.Logs {
+		cl.ConvertMap.addGrokTypes(cl.Regexp)
+	}
+	for _, ce := range c.Execs {
+		ce.ConvertMap.addGrokTypes(ce.Regexp)
+	}
+	return nil
+}
+
 func NewConfigByArgs(args []string, fieldName string, monitorAddr string) *Config {
 	tag := args[0]
 	file := args[1]
@@ -124,12 +445,18 @@ func (cs *ConfigServer) Restrict(c *Config) {
 	if cs.Port == 0 {
 		cs.Port = DefaultFluentdPort
 	}
+	if cs.Output == "" {
+		cs.Output = DefaultOutputName
+	}
+	cs.ConfigAuth.Restrict(c)
 }
 
 func (cr *ConfigReceiver) Restrict(c *Config) {
 	if cr.Port == 0 {
 		cr.Port = DefaultFluentdPort
 	}
+	cr.ConfigAuth.Restrict(c)
+	cr.ConfigFilters.Restrict(c, "in_forward")
 	switch cr.MaxBufferMessages {
 	case 0:
 		cr.MaxBufferMessages = DefaultMaxBufferMessages
@@ -138,6 +465,54 @@ func (cr *ConfigReceiver) Restrict(c *Config) {
 	}
 }
 
+func (cs *ConfigSyslog) Restrict(c *Config) {
+	if cs.Tag == "" {
+		cs.Tag = DefaultSyslogTag
+	}
+	if c.TagPrefix != "" {
+		cs.Tag = c.TagPrefix + "." + cs.Tag
+	}
+	if cs.Protocol == "" {
+		cs.Protocol = "udp"
+	}
+	if cs.Port == 0 {
+		cs.Port = DefaultSyslogPort
+	}
+	if cs.FieldName == "" {
+		cs.FieldName = c.FieldName
+	}
+}
+
+func (ch *ConfigHTTP) Restrict(c *Config) {
+	if ch.Port == 0 {
+		ch.Port = DefaultHTTPPort
+	}
+	if ch.TagPrefix == "" {
+		ch.TagPrefix = c.TagPrefix
+	}
+	if ch.MaxBodySize == 0 {
+		ch.MaxBodySize = DefaultHTTPMaxBodySize
+	}
+}
+
+func (ce *ConfigExec) Restrict(c *Config) {
+	if ce.FieldName == "" {
+		ce.FieldName = c.FieldName
+	}
+	if c.TagPrefix != "" {
+		ce.Tag = c.TagPrefix + "." + ce.Tag
+	}
+	if ce.TimeKey == "" {
+		ce.TimeKey = DefaultTimeKey
+	}
+	if ce.TimeFormat == "" {
+		ce.TimeFormat = DefaultTimeFormat
+	}
+	if ce.RestartWait.Duration == 0 {
+		ce.RestartWait.Duration = DefaultExecRestartWait
+	}
+}
+
 func (cs *ConfigServer) Address() string {
 	return fmt.Sprintf("%s:%d", cs.Host, cs.Port)
 }
@@ -146,6 +521,11 @@ func (cl *ConfigLogfile) IsStdin() bool {
 	return cl.File == StdinFilename
 }
 
+// IsGlob returns true if File is a glob pattern.
+func (cl *ConfigLogfile) IsGlob() bool {
+	return !cl.IsStdin() && strings.ContainsAny(cl.File, "*?[")
+}
+
 func (cl *ConfigLogfile) Restrict(c *Config) {
 	if cl.FieldName == "" {
 		cl.FieldName = c.FieldName
@@ -159,6 +539,89 @@ func (cl *ConfigLogfile) Restrict(c *Config) {
 	if cl.TimeFormat == "" {
 		cl.TimeFormat = DefaultTimeFormat
 	}
+	cl.ConfigFilters.Restrict(c, cl.Tag)
+}
+
+func (cb *ConfigBuffer) Restrict(c *Config) {
+	if cb.MaxBytes == 0 {
+		cb.MaxBytes = DefaultBufferMaxBytes
+	}
+	if cb.MaxAge.Duration == 0 {
+		cb.MaxAge.Duration = DefaultBufferMaxAge
+	}
+}
+
+func (co *ConfigOutput) Restrict(c *Config) {
+	for _, subconf := range co.Servers {
+		subconf.Restrict(c)
+		subconf.Output = co.Name
+	}
+	if co.Buffer != nil {
+		co.Buffer.Restrict(c)
+	}
+}
+
+// restrictOutputs groups Servers by Output into Outputs, which precede Outputs defined explicitly.
+// Buffer is shared by the groups, and outputs except "default" store chunks into {Path}/{Output}.
+func (c *Config) restrictOutputs() {
+	outputs := make([]*ConfigOutput, 0, len(c.Outputs)+1)
+	index := make(map[string]*ConfigOutput)
+	for _, server := range c.Servers {
+		o, ok := index[server.Output]
+		if !ok {
+			o = &ConfigOutput{
+				Name:             server.Output,
+				ServerRoundRobin: c.ServerRoundRobin,
+			}
+			if c.Buffer != nil {
+				buffer := *c.Buffer
+				if o.Name != DefaultOutputName {
+					buffer.Path = filepath.Join(buffer.Path, o.Name)
+				}
+				o.Buffer = &buffer
+			}
+			index[o.Name] = o
+			outputs = append(outputs, o)
+		}
+		o.Servers = append(o.Servers, server)
+	}
+	for i, o := range c.Outputs {
+		if o.Name == "" {
+			o.Name = fmt.Sprintf("output[%d]", i)
+		}
+		o.Restrict(c)
+		outputs = append(outputs, o)
+	}
+	c.Outputs = outputs
+}
+
+// Validate returns an error for the restricted config which can't be run.
+func (c *Config) Validate() error {
+	outputs := make(map[string]bool, len(c.Outputs))
+	for _, o := range c.Outputs {
+		if outputs[o.Name] {
+			return fmt.Errorf("Output %s is defined twice (by Output of Servers or Name of Outputs)", o.Name)
+		}
+		outputs[o.Name] = true
+	}
+	for _, m := range c.Routes() {
+		if !outputs[m.Output] {
+			return fmt.Errorf("Output %s for Match %s is not defined", m.Output, m.Pattern)
+		}
+	}
+	return nil
+}
+
+// Routes returns Matches followed by Match of Outputs.
+func (c *Config) Routes() []*ConfigMatch {
+	routes := make([]*ConfigMatch, 0, len(c.Matches)+len(c.Outputs))
+	routes = append(routes, c.Matches...)
+	for _, o := range c.Outputs {
+		if o.Match != nil {
+			routes = append(routes, &ConfigMatch{Pattern: o.Match, Output: o.Name, Copy: o.Copy})
+		}
+	}
+	return routes
 }
 
 func (cr *ConfigMonitor) Restrict(c *Config) {
@@ -171,6 +634,12 @@ func (c *Config) Restrict() {
 	if c.FieldName == "" {
 		c.FieldName = DefaultFieldName
 	}
+	if c.AckResponseTimeout.Duration == 0 {
+		c.AckResponseTimeout.Duration = DefaultAckResponseTimeout
+	}
+	if c.SelfHostname == "" {
+		c.SelfHostname, _ = os.Hostname()
+	}
 	for _, subconf := range c.Servers {
 		subconf.Restrict(c)
 	}
@@ -180,4 +649,17 @@ func (c *Config) Restrict() {
 	if c.Receiver != nil {
 		c.Receiver.Restrict(c)
 	}
+	if c.Buffer != nil {
+		c.Buffer.Restrict(c)
+	}
+	c.restrictOutputs()
+	for _, subconf := range c.Syslogs {
+		subconf.Restrict(c)
+	}
+	if c.HTTP != nil {
+		c.HTTP.Restrict(c)
+	}
+	for _, subconf := range c.Execs {
+		subconf.Restrict(c)
+	}
 }
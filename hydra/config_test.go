@@ -2,7 +2,11 @@ package hydra_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fujiwara/fluent-agent-hydra/hydra"
 )
@@ -32,17 +36,49 @@ func TestReadConfig(t *testing.T) {
 	if config.Servers[0].Host != "127.0.0.1" || config.Servers[0].Port != 24224 {
 		t.Errorf("invalid Servers[0] got %#v", config.Servers[0])
 	}
-	if config.Servers[1].Host != "127.0.0.1" || config.Servers[1].Port != 24225 {
+	if config.Servers[1].Host != "127.0.0.1" || config.Servers[1].Port != 24225 ||
+		!config.Servers[1].TLS || config.Servers[1].CAFile != "/etc/hydra/ca.pem" {
 		t.Errorf("invalid Servers[1] got %#v", config.Servers[1])
 	}
+	if config.Servers[0].Output != "default" || config.Servers[1].Output != "audit" {
+		t.Errorf("invalid Servers Output got %s, %s", config.Servers[0].Output, config.Servers[1].Output)
+	}
+	if len(config.Matches) != 1 {
+		t.Errorf("invalid Match got %#v", config.Matches)
+	} else if m := config.Matches[0]; m.Output != "audit" ||
+		!m.Pattern.Match("foo.grok.ERROR") ||
+		!m.Pattern.Match("foo.audit.login.failed") ||
+		m.Pattern.Match("foo.grok.INFO") {
+		t.Errorf("invalid Match[0] got %#v", m)
+	}
+	if len(config.Outputs) != 3 {
+		t.Errorf("invalid Outputs got %#v", config.Outputs)
+	} else {
+		for i, name := range []string{"default", "audit", "archive"} {
+			if o := config.Outputs[i]; o.Name != name || !o.ServerRoundRobin || o.Buffer != nil && name != "archive" {
+				t.Errorf("invalid Outputs[%d] got %#v", i, o)
+			}
+		}
+		if o := config.Outputs[2]; len(o.Servers) != 2 ||
+			o.Servers[0].Port != 24224 || o.Servers[0].Output != "archive" ||
+			o.Servers[1].Host != "archive2.example.com" || o.Servers[1].Port != 24225 ||
+			!o.Copy || !o.Match.Match("foo.any.tag") ||
+			o.Buffer == nil || o.Buffer.Path != "/tmp/hydra-archive" || o.Buffer.MaxBytes != hydra.DefaultBufferMaxBytes {
+			t.Errorf("invalid Outputs[2] got %#v", o)
+		}
+	}
+	if routes := config.Routes(); len(routes) != 2 || routes[1].Output != "archive" || !routes[1].Copy {
+		t.Errorf("invalid Routes got %#v", routes)
+	}
 
-	if len(config.Logs) != 5 {
+	if len(config.Logs) != 8 {
 		t.Errorf("invalid Logs got %#v", config.Logs)
 	}
 	if c := config.Logs[0]; c.Tag != "foo.tag1" ||
 		c.File != "/tmp/foo.log" ||
 		c.FieldName != "message" ||
-		c.TimeParse != false {
+		c.TimeParse != false ||
+		c.RotateWait.Duration != 0 {
 		t.Errorf("invalid Logs[0] got %#v", c)
 	}
 
@@ -78,11 +114,171 @@ func TestReadConfig(t *testing.T) {
 		t.Errorf("invalid Logs[4] got %#v", c)
 	}
 
+	if c := config.Logs[5]; c.Tag != "foo.multiline" ||
+		c.MultilineStart.String() != `^\d{4}-\d{2}-\d{2} ` ||
+		c.MultilineFlushInterval.Duration != 5*time.Second {
+		t.Errorf("invalid Logs[5] got %#v", c)
+	}
+
+	if c := config.Logs[6]; c.Tag != "foo.csv" ||
+		c.Format != hydra.FormatCSV ||
+		strings.Join(c.Keys, ",") != "id,name,size" ||
+		c.Delimiter != ";" {
+		t.Errorf("invalid Logs[6] got %#v", c)
+	}
+
+	if c := config.Logs[7]; c.Tag != "foo.grok" ||
+		c.Regexp.Regexp == nil ||
+		!c.Regexp.MatchString("2016-01-02T03:04:05Z [INFO] started") {
+		t.Errorf("invalid Logs[7] got %#v", c)
+	} else if len(c.Grep) != 1 {
+		t.Errorf("invalid Logs[7].Grep got %#v", c.Grep)
+	} else if g := c.Grep[0]; g.Name != "foo.grok grep[0]" ||
+		g.Key != "message" ||
+		!g.Pattern.MatchString("GET /healthcheck") ||
+		!g.Exclude ||
+		g.TagPattern != nil {
+		t.Errorf("invalid Logs[7].Grep[0] got %#v", g)
+	}
+	if c := config.Logs[7]; len(c.Transform) != 1 {
+		t.Errorf("invalid Logs[7].Transform got %#v", c.Transform)
+	} else if tr := c.Transform[0]; tr.Name != "foo.grok transform[0]" ||
+		tr.AddFields["host"] != "${hostname}" ||
+		len(tr.RemoveFields) != 1 {
+		t.Errorf("invalid Logs[7].Transform[0] got %#v", tr)
+	}
+	if c := config.Logs[7]; len(c.Mask) != 1 {
+		t.Errorf("invalid Logs[7].Mask got %#v", c.Mask)
+	} else if m := c.Mask[0]; m.Name != "foo.grok mask[0]" ||
+		len(m.Fields) != 1 ||
+		len(m.Detectors) != 2 ||
+		len(m.Patterns) != 1 || !m.Patterns[0].MatchString("session=abc123") ||
+		m.Method != "hmac" ||
+		m.HMACKey != "secret" {
+		t.Errorf("invalid Logs[7].Mask[0] got %#v", m)
+	}
+	if c := config.Logs[7]; len(c.RewriteTag) != 1 {
+		t.Errorf("invalid Logs[7].RewriteTag got %#v", c.RewriteTag)
+	} else if r := c.RewriteTag[0]; r.Name != "foo.grok rewrite_tag[0]" ||
+		r.Key != "message" ||
+		!r.Pattern.MatchString("[WARN] disk full") ||
+		r.Tag != "${tag}.${level}" {
+		t.Errorf("invalid Logs[7].RewriteTag[0] got %#v", r)
+	}
+
 	if config.Receiver.Host != "localhost" || config.Receiver.Port != 24224 {
 		t.Errorf("invalid Receiver got %#v", config.Receiver)
 	}
 
+	if len(config.Syslogs) != 1 {
+		t.Errorf("invalid Syslogs got %#v", config.Syslogs)
+	} else if c := config.Syslogs[0]; c.Tag != "foo.syslog" ||
+		c.Protocol != "tcp" ||
+		c.Port != 5514 ||
+		c.FieldName != "message" {
+		t.Errorf("invalid Syslogs[0] got %#v", c)
+	}
+
+	if len(config.Execs) != 1 {
+		t.Errorf("invalid Execs got %#v", config.Execs)
+	} else if c := config.Execs[0]; c.Tag != "foo.exec" ||
+		c.Command != `printf 'foo:1\tbar:2\n'` ||
+		c.Interval.Duration != time.Minute ||
+		c.RestartWait.Duration != hydra.DefaultExecRestartWait ||
+		c.Format != hydra.FormatLTSV ||
+		c.FieldName != "message" {
+		t.Errorf("invalid Execs[0] got %#v", c)
+	}
+
 	if config.Monitor.Host != "127.0.0.2" || config.Monitor.Port != 24223 {
 		t.Errorf("invalid Monitor got %#v", config.Monitor)
 	}
 }
+
+var invalidConfigs = []string{
+	// Match to the undefined output
+	`
+[[Servers]]
+Host = "127.0.0.1"
+
+[[Match]]
+Pattern = "foo.**"
+Output = "audit"
+`,
+	// Output defined by both Servers and Outputs
+	`
+[[Servers]]
+Host = "127.0.0.1"
+Output = "audit"
+
+[[Outputs]]
+Name = "audit"
+  [[Outputs.Servers]]
+  Host = "127.0.0.2"
+`,
+	// Outputs which have the same name
+	`
+[[Outputs]]
+Name = "archive"
+  [[Outputs.Servers]]
+  Host = "127.0.0.1"
+
+[[Outputs]]
+Name = "archive"
+  [[Outputs.Servers]]
+  Host = "127.0.0.2"
+`,
+	// unknown grok pattern
+	`
+[[Logs]]
+File = "/tmp/foo.log"
+Format = "Regexp"
+Regexp = "%{UNKNOWN:foo}"
+`,
+	// unsupported type in grok pattern
+	`
+[[Logs]]
+File = "/tmp/foo.log"
+Format = "Regexp"
+Regexp = "%{INT:size:bool}"
+`,
+}
+
+func TestReadConfigInvalid(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	for i, content := range invalidConfigs {
+		file, _ := ioutil.TempFile(tmpdir, "config.")
+		file.WriteString(content)
+		file.Close()
+		if _, err := hydra.ReadConfig(file.Name()); err == nil {
+			t.Errorf("invalidConfigs[%d] must be an error", i)
+		}
+	}
+}
+
+func TestReadConfigGrokTypes(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	file, _ := ioutil.TempFile(tmpdir, "config.")
+	file.WriteString(`
+[[Logs]]
+File = "/tmp/foo.log"
+Format = "Regexp"
+Regexp = "%{INT:status:int} %{NUMBER:time:float} %{INT:size:int}"
+Types = "size:float"
+`)
+	file.Close()
+	config, err := hydra.ReadConfig(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := config.Logs[0]
+	mod := hydra.NewRecordModifier(cl.ConvertMap, false, "", "")
+	r := hydra.NewFluentRecordRegexp("message", []byte("200 0.5 1024"), cl.Regexp)
+	mod.Modify(r)
+	// Types take precedence over types in the grok pattern
+	if r.Data["status"] != int64(200) || r.Data["time"] != 0.5 || r.Data["size"] != 1024.0 {
+		t.Errorf("unexpected record %#v", r.Data)
+	}
+}
@@ -0,0 +1,171 @@
+package hydra
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"unicode/utf8"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const csvMaxRecordSize = 1024 * 1024
+
+// CSVParser parses CSV (RFC4180) or TSV lines into records.
+// When Keys are not defined, the first line of a stream is used as the header.
+// Quoted fields may contain line breaks, so a parser keeps lines until the quotes are closed.
+type CSVParser struct {
+	delimiter    rune
+	keys         []string
+	fromHeader   bool
+	pending      []byte
+	pendingBytes int
+}
+
+// NewCSVParser returns nil when the format is neither CSV nor TSV.
+func NewCSVParser(format FileFormat, keys []string, delimiter string) (*CSVParser, error) {
+	p := &CSVParser{keys: keys}
+	switch format {
+	case FormatCSV:
+		p.delimiter = ','
+	case FormatTSV:
+		p.delimiter = '\t'
+	default:
+		return nil, nil
+	}
+	if delimiter != "" {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return nil, fmt.Errorf("Invalid Delimiter %q", delimiter)
+		}
+		p.delimiter = r
+	}
+	p.fromHeader = len(p.keys) == 0
+	return p, nil
+}
+
+// New returns a CSVParser which has the same settings for a new stream.
+func (p *CSVParser) New() *CSVParser {
+	if p == nil {
+		return nil
+	}
+	n := *p
+	n.pending = nil
+	n.pendingBytes = 0
+	if n.fromHeader {
+		n.keys = nil
+	}
+	return &n
+}
+
+// NeedsHeader returns true when the header line has not been read yet.
+func (p *CSVParser) NeedsHeader() bool {
+	return p != nil && p.fromHeader && p.keys == nil
+}
+
+// ReadHeader reads the header from the head of the file (for starting in the middle of the file).
+func (p *CSVParser) ReadHeader(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for p.NeedsHeader() {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 {
+			return err
+		}
+		p.Parse("", line)
+		if err != nil {
+			break
+		}
+	}
+	p.pending = nil
+	p.pendingBytes = 0
+	return nil
+}
+
+// Parse returns a record of the line. The header line, empty lines and
+// lines ending in a quoted field (continued to the next line) return nil.
+func (p *CSVParser) Parse(key string, line []byte) *fluent.TinyFluentRecord {
+	// the line separator was removed from the line
+	size := len(line) + len(LineSeparator)
+	line = bytes.TrimRight(line, "\r\n")
+	if p.pending != nil {
+		line = append(append(p.pending, '\n'), line...)
+		p.pending = nil
+	}
+	pendingBytes := p.pendingBytes
+	p.pendingBytes = 0
+	if len(line) == 0 {
+		return nil
+	}
+	if p.inQuotes(line) {
+		if len(line) <= csvMaxRecordSize {
+			p.pending = append([]byte(nil), line...)
+			p.pendingBytes = pendingBytes + size
+			return nil
+		}
+		log.Printf("[warning] quoted CSV field is not closed in %d bytes", csvMaxRecordSize)
+	}
+	r := csv.NewReader(bytes.NewReader(line))
+	r.Comma = p.delimiter
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	if p.NeedsHeader() {
+		if err != nil {
+			log.Println("[warning] invalid CSV header", err)
+			return nil
+		}
+		p.keys = fields
+		return nil
+	}
+	data := make(map[string]interface{}, len(p.keys))
+	if err != nil {
+		// invalid CSV format.
+		data[key] = string(line)
+		return &fluent.TinyFluentRecord{Data: data}
+	}
+	for i, name := range p.keys {
+		if i >= len(fields) {
+			break
+		}
+		data[name] = fields[i]
+	}
+	return &fluent.TinyFluentRecord{Data: data}
+}
+
+// Pending returns bytes length of the pending lines in the file (includes line separators).
+func (p *CSVParser) Pending() int {
+	if p == nil {
+		return 0
+	}
+	return p.pendingBytes
+}
+
+// inQuotes returns true if the data ends in a quoted field.
+func (p *CSVParser) inQuotes(data []byte) bool {
+	quoted, start := false, true
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		i += size
+		if quoted {
+			if r == '"' {
+				if i < len(data) && data[i] == '"' {
+					// escaped quote
+					i++
+				} else {
+					quoted = false
+				}
+			}
+		} else if start && r == '"' {
+			quoted = true
+		}
+		start = !quoted && (r == p.delimiter || r == '\n')
+	}
+	return quoted
+}
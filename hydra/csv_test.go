@@ -0,0 +1,112 @@
+package hydra_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestCSVParserKeys(t *testing.T) {
+	p, err := hydra.NewCSVParser(hydra.FormatCSV, []string{"id", "name", "note"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := hydra.NewRecordModifier(hydra.NewConvertMap("id:integer"), false, "", "")
+	buf := []byte("1,foo,bar\n2,\"a,b\",\"say \"\"hello\"\"\"\n\n3,baz\n4,\"bro\"ken")
+	rs := hydra.NewFluentRecordSetCSV("csv", "message", mod, p, buf)
+	expected := []map[string]interface{}{
+		{"id": int64(1), "name": "foo", "note": "bar"},
+		{"id": int64(2), "name": "a,b", "note": `say "hello"`},
+		{"id": int64(3), "name": "baz"},
+		{"message": `4,"bro"ken`},
+	}
+	if len(rs.Records) != len(expected) {
+		t.Fatalf("unexpected records %#v", rs.Records)
+	}
+	for i, r := range rs.Records {
+		for k, v := range expected[i] {
+			if d, _ := r.GetData(k); d != v {
+				t.Errorf("records[%d] %s expected %#v got %#v", i, k, v, d)
+			}
+		}
+	}
+	if _, ok := rs.Records[2].GetData("note"); ok {
+		t.Errorf("missing column must not be set %#v", rs.Records[2])
+	}
+}
+
+func TestCSVParserHeader(t *testing.T) {
+	p, err := hydra.NewCSVParser(hydra.FormatTSV, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.NeedsHeader() {
+		t.Error("header must be required without keys")
+	}
+	rs := hydra.NewFluentRecordSetCSV("tsv", "message", nil, p, []byte("a\tb\n1\t2"))
+	if len(rs.Records) != 1 {
+		t.Fatalf("unexpected records %#v", rs.Records)
+	}
+	if a, _ := rs.Records[0].GetData("a"); a != "1" {
+		t.Errorf("unexpected record %#v", rs.Records[0])
+	}
+
+	// a new stream reads its own header
+	n := p.New()
+	rs = hydra.NewFluentRecordSetCSV("tsv", "message", nil, n, []byte("x\ty\n3\t4"))
+	if x, _ := rs.Records[0].GetData("x"); x != "3" {
+		t.Errorf("unexpected record %#v", rs.Records[0])
+	}
+}
+
+func TestCSVParserMultiline(t *testing.T) {
+	p, err := hydra.NewCSVParser(hydra.FormatCSV, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := []byte("id,\"multi\nline\"\n1,\"first\nsecond\n\nfourth \"\"quoted\"\"\"\n2,\"single\"\n3,\"continued")
+	rs := hydra.NewFluentRecordSetCSV("csv", "message", nil, p, buf)
+	if len(rs.Records) != 2 {
+		t.Fatalf("unexpected records %#v", rs.Records)
+	}
+	if v, _ := rs.Records[0].GetData("multi\nline"); v != "first\nsecond\n\nfourth \"quoted\"" {
+		t.Errorf("unexpected record %#v", rs.Records[0])
+	}
+	if v, _ := rs.Records[1].GetData("multi\nline"); v != "single" {
+		t.Errorf("unexpected record %#v", rs.Records[1])
+	}
+	if n := p.Pending(); n != len("3,\"continued\n") {
+		t.Errorf("unexpected pending bytes %d", n)
+	}
+
+	// a quoted field continues over record sets in the stream
+	rs = hydra.NewFluentRecordSetCSV("csv", "message", nil, p, []byte("in the next read\""))
+	if len(rs.Records) != 1 {
+		t.Fatalf("unexpected records %#v", rs.Records)
+	}
+	if v, _ := rs.Records[0].GetData("multi\nline"); v != "continued\nin the next read" {
+		t.Errorf("unexpected record %#v", rs.Records[0])
+	}
+	if n := p.Pending(); n != 0 {
+		t.Errorf("unexpected pending bytes %d", n)
+	}
+}
+
+func TestCSVParserDelimiter(t *testing.T) {
+	p, err := hydra.NewCSVParser(hydra.FormatCSV, []string{"a", "b"}, ";")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := hydra.NewFluentRecordSetCSV("csv", "message", nil, p, []byte("1;2,3"))
+	if b, _ := rs.Records[0].GetData("b"); b != "2,3" {
+		t.Errorf("unexpected record %#v", rs.Records[0])
+	}
+	for _, d := range []string{"ab", "\"", "\n"} {
+		if _, err := hydra.NewCSVParser(hydra.FormatCSV, nil, d); err == nil {
+			t.Errorf("delimiter %q must be invalid", d)
+		}
+	}
+	if p, _ := hydra.NewCSVParser(hydra.FormatJSON, nil, ""); p != nil {
+		t.Error("parser must be nil for JSON")
+	}
+}
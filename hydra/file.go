@@ -15,6 +15,7 @@ const (
 	SEEK_TAIL         = int64(-1)
 	SEEK_HEAD         = int64(0)
 	DEBUG             = false
+	RotatedFileSuffix = " (rotated)"
 )
 
 var (
@@ -34,6 +35,12 @@ type File struct {
 	Format         FileFormat
 	RecordModifier *RecordModifier
 	Regexp         *Regexp
+	CSV            *CSVParser
+	Filters        Filters
+	PositionFile   *PositionFile
+	Multiline      *Multiline
+	Rotated        bool
+	inode          uint64
 }
 
 func openFile(path string, startPos int64) (*File, error) {
@@ -47,18 +54,15 @@ func openFile(path string, startPos int64) (*File, error) {
 	}
 
 	file := &File{
-		f,
-		path,
-		"",
-		startPos,
-		make([]byte, ReadBufferSize),
-		make([]byte, 0),
-		stat,
-		"",
-		&FileStat{},
-		FormatNone,
-		nil,
-		nil,
+		File:     f,
+		Path:     path,
+		Position: startPos,
+		readBuf:  make([]byte, ReadBufferSize),
+		contBuf:  make([]byte, 0),
+		lastStat: stat,
+		FileStat: &FileStat{},
+		Format:   FormatNone,
+		inode:    inodeOf(stat),
 	}
 
 	if startPos == SEEK_TAIL {
@@ -93,6 +97,9 @@ func (f *File) tailAndSend(messageCh chan *fluent.FluentRecordSet, monitorCh cha
 	for {
 		n, err := io.ReadAtLeast(f, f.readBuf, 1)
 		if n == 0 || err == io.EOF {
+			if f.Multiline != nil && f.Multiline.Expired() {
+				f.FlushMultiline(messageCh, monitorCh)
+			}
 			return err
 		} else if err != nil {
 			return err
@@ -122,13 +129,77 @@ func (f *File) tailAndSend(messageCh chan *fluent.FluentRecordSet, monitorCh cha
 				copy(f.contBuf, f.readBuf[blockLen+1:n])
 			}
 		}
-		messageCh <- NewFluentRecordSet(f.Tag, f.FieldName, f.Format, f.RecordModifier, f.Regexp, sendBuf)
+		if f.Multiline != nil {
+			events := f.Multiline.Feed(bytes.Split(sendBuf, LineSeparator))
+			if len(events) == 0 {
+				continue
+			}
+			f.send(newFluentRecordSet(f.Tag, f.FieldName, f.Format, f.RecordModifier, f.Regexp, f.CSV, events), messageCh, monitorCh)
+		} else {
+			f.send(newFluentRecordSet(f.Tag, f.FieldName, f.Format, f.RecordModifier, f.Regexp, f.CSV, bytes.Split(sendBuf, LineSeparator)), messageCh, monitorCh)
+		}
+		f.SavePosition()
 		monitorCh <- f.UpdateStat()
 	}
 }
 
+// FlushMultiline sends a pending multiline event.
+func (f *File) FlushMultiline(messageCh chan *fluent.FluentRecordSet, monitorCh chan Stat) {
+	if f.Multiline == nil {
+		return
+	}
+	e := f.Multiline.Flush()
+	if e == nil {
+		return
+	}
+	f.send(newFluentRecordSet(f.Tag, f.FieldName, f.Format, f.RecordModifier, f.Regexp, f.CSV, [][]byte{e}), messageCh, monitorCh)
+	f.SavePosition()
+	monitorCh <- f.UpdateStat()
+}
+
+// send sends the record set which passed filters.
+func (f *File) send(rs *fluent.FluentRecordSet, messageCh chan *fluent.FluentRecordSet, monitorCh chan Stat) {
+	for _, rs := range f.Filters.Apply(rs, monitorCh) {
+		messageCh <- rs
+	}
+}
+
+// SavePosition writes the position of sent lines to the position file.
+func (f *File) SavePosition() {
+	if f.PositionFile == nil {
+		return
+	}
+	// continuous line in f.contBuf, pending multiline event and CSV record are not sent yet
+	pos := f.Position - int64(len(f.contBuf))
+	if f.Multiline != nil {
+		pos -= int64(f.Multiline.Pending())
+	}
+	pos -= int64(f.CSV.Pending())
+	key := f.positionKey()
+	if err := f.PositionFile.Update(key, f.inode, pos); err != nil {
+		log.Println("[warning]", f.Path, "couldn't save position", err)
+	}
+	// the position file may defer writing
+	if persisted, ok := f.PositionFile.Persisted(key); ok {
+		f.FileStat.PersistedPosition = persisted
+	}
+}
+
+// positionKey returns a key of the position file. A rotated file is recorded apart from the new file.
+func (f *File) positionKey() string {
+	if f.Rotated {
+		return f.Path + RotatedFileSuffix
+	}
+	return f.Path
+}
+
 func (f *File) UpdateStat() *FileStat {
-	f.FileStat.File = f.Path
+	if f.Rotated {
+		f.FileStat.File = f.Path + RotatedFileSuffix
+		f.FileStat.Rotated = true
+	} else {
+		f.FileStat.File = f.Path
+	}
 	f.FileStat.Position = f.Position
 	f.FileStat.Tag = f.Tag
 	return f.FileStat
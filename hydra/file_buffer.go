@@ -0,0 +1,326 @@
+package hydra
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBufferMaxBytes = 256 * 1024 * 1024
+	DefaultBufferMaxAge   = 24 * time.Hour
+	BufferChunkSuffix     = ".chunk"
+)
+
+// BufferChunk is a chunk of packed entries stored in FileBuffer.
+type BufferChunk struct {
+	Tag       string
+	Entries   []byte
+	Messages  int
+	CreatedAt time.Time
+	path      string
+	size      int64
+}
+
+// FileBuffer stores chunks as files in the directory, and replays them in order.
+// Chunks remain after restart of the agent.
+type FileBuffer struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	chunks   []*BufferChunk
+	bytes    int64
+	seq      int64
+	disposed int64
+	closed   bool
+	sending  *BufferChunk // peeked and not removed yet
+	mu       sync.Mutex
+	cond     *sync.Cond
+}
+
+func NewFileBuffer(config *ConfigBuffer) (*FileBuffer, error) {
+	dir, err := Rel2Abs(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b := &FileBuffer{
+		dir:      dir,
+		maxBytes: config.MaxBytes,
+		maxAge:   config.MaxAge.Duration,
+	}
+	b.cond = sync.NewCond(&b.mu)
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	if len(b.chunks) > 0 {
+		log.Printf("[info] %d buffered chunks (%d bytes) found in %s", len(b.chunks), b.bytes, dir)
+	}
+	return b, nil
+}
+
+// load restores chunks which were left by the previous process.
+func (b *FileBuffer) load() error {
+	// temporary files which were not renamed to chunks (crashed in writing)
+	tmps, err := filepath.Glob(filepath.Join(b.dir, "*"+BufferChunkSuffix+".tmp"))
+	if err != nil {
+		return err
+	}
+	for _, path := range tmps {
+		log.Println("[warning] remove incomplete chunk file", path)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(b.dir, "*"+BufferChunkSuffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, path := range files {
+		name := strings.TrimSuffix(filepath.Base(path), BufferChunkSuffix)
+		parts := strings.SplitN(name, ".", 2)
+		if len(parts) != 2 {
+			log.Println("[warning] ignore invalid chunk file name", path)
+			continue
+		}
+		nsec, err1 := strconv.ParseInt(parts[0], 16, 64)
+		seq, err2 := strconv.ParseInt(parts[1], 16, 64)
+		if err1 != nil || err2 != nil {
+			log.Println("[warning] ignore invalid chunk file name", path)
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		b.chunks = append(b.chunks, &BufferChunk{
+			CreatedAt: time.Unix(0, nsec),
+			path:      path,
+			size:      stat.Size(),
+		})
+		b.bytes += stat.Size()
+		if seq >= b.seq {
+			b.seq = seq + 1
+		}
+	}
+	return nil
+}
+
+// Push stores packed entries as a new chunk.
+func (b *FileBuffer) Push(tag string, entries []byte, messages int) error {
+	now := time.Now()
+	b.mu.Lock()
+	seq := b.seq
+	b.seq++
+	b.mu.Unlock()
+
+	path := filepath.Join(b.dir, fmt.Sprintf("%016x.%08x%s", now.UnixNano(), seq, BufferChunkSuffix))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\t%d\n", tag, messages)
+	buf.Write(entries)
+	if err := writeFileSync(path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.chunks = append(b.chunks, &BufferChunk{
+		CreatedAt: now,
+		path:      path,
+		size:      int64(buf.Len()),
+	})
+	b.bytes += int64(buf.Len())
+	b.expire()
+	for b.maxBytes > 0 && b.bytes > b.maxBytes {
+		// the chunk being sent and the newest chunk are never disposed
+		i := b.oldest()
+		if i < 0 || i == len(b.chunks)-1 {
+			break
+		}
+		log.Println("[warning] buffer is full. dispose the oldest chunk", b.chunks[i].path)
+		b.dispose(i)
+	}
+	b.cond.Signal()
+	return nil
+}
+
+// Peek returns the oldest chunk without removing it. Peek blocks until any chunk is pushed.
+// It returns nil after Close was called and the buffer is empty.
+// The returned chunk is never disposed by limits until Remove is called.
+func (b *FileBuffer) Peek() (*BufferChunk, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		b.expire()
+		if len(b.chunks) > 0 {
+			break
+		}
+		if b.closed {
+			return nil, nil
+		}
+		b.cond.Wait()
+	}
+	chunk := b.chunks[0]
+	if chunk.Entries == nil {
+		if err := chunk.read(); err != nil {
+			log.Println("[error] dispose the broken chunk", chunk.path, err)
+			b.dispose(0)
+			return nil, err
+		}
+	}
+	b.sending = chunk
+	return chunk, nil
+}
+
+// Remove removes the chunk which was sent.
+func (b *FileBuffer) Remove(chunk *BufferChunk) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sending == chunk {
+		b.sending = nil
+	}
+	for i, c := range b.chunks {
+		if c == chunk {
+			b.chunks = append(b.chunks[:i], b.chunks[i+1:]...)
+			b.bytes -= c.size
+			break
+		}
+	}
+	if err := os.Remove(chunk.path); err != nil && !os.IsNotExist(err) {
+		log.Println("[warning]", err)
+	}
+}
+
+// Close wakes up Peek waiting for chunks. Stored chunks are kept in the directory.
+func (b *FileBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+func (b *FileBuffer) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *FileBuffer) Stat() *BufferStat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &BufferStat{
+		Path:     b.dir,
+		Chunks:   len(b.chunks),
+		Bytes:    b.bytes,
+		MaxBytes: b.maxBytes,
+		Disposed: b.disposed,
+	}
+	if len(b.chunks) > 0 {
+		s.OldestChunkAge = time.Since(b.chunks[0].CreatedAt).Seconds()
+	}
+	return s
+}
+
+// expire disposes chunks older than maxAge except the chunk being sent. must be called with lock.
+func (b *FileBuffer) expire() {
+	if b.maxAge <= 0 {
+		return
+	}
+	for {
+		i := b.oldest()
+		if i < 0 || time.Since(b.chunks[i].CreatedAt) <= b.maxAge {
+			return
+		}
+		log.Println("[warning] dispose the expired chunk", b.chunks[i].path)
+		b.dispose(i)
+	}
+}
+
+// oldest returns the index of the oldest chunk which is not being sent, or -1. must be called with lock.
+func (b *FileBuffer) oldest() int {
+	for i, c := range b.chunks {
+		if c != b.sending {
+			return i
+		}
+	}
+	return -1
+}
+
+// dispose removes the chunk which was not sent. must be called with lock.
+func (b *FileBuffer) dispose(i int) {
+	chunk := b.chunks[i]
+	b.chunks = append(b.chunks[:i], b.chunks[i+1:]...)
+	b.bytes -= chunk.size
+	b.disposed++
+	if err := os.Remove(chunk.path); err != nil && !os.IsNotExist(err) {
+		log.Println("[warning]", err)
+	}
+}
+
+// writeFileSync writes data to path atomically, and syncs it to the disk.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// sync the directory to persist the renamed entry
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (c *BufferChunk) read() error {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	n := bytes.IndexByte(data, '\n')
+	if n < 0 {
+		return fmt.Errorf("chunk header not found")
+	}
+	cols := strings.SplitN(string(data[:n]), "\t", 2)
+	if len(cols) != 2 {
+		return fmt.Errorf("invalid chunk header %q", data[:n])
+	}
+	messages, err := strconv.Atoi(cols[1])
+	if err != nil {
+		return err
+	}
+	c.Tag = cols[0]
+	c.Messages = messages
+	c.Entries = data[n+1:]
+	return nil
+}
@@ -0,0 +1,201 @@
+package hydra_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func newConfigBuffer(dir string) *hydra.ConfigBuffer {
+	return &hydra.ConfigBuffer{
+		Path:     dir,
+		MaxBytes: hydra.DefaultBufferMaxBytes,
+		MaxAge:   hydra.Duration{Duration: hydra.DefaultBufferMaxAge},
+	}
+}
+
+func TestFileBufferReplay(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	b, err := hydra.NewFileBuffer(newConfigBuffer(tmpdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := []string{"test.a", "test.b", "test.c"}
+	for i, tag := range tags {
+		if err := b.Push(tag, []byte(tag), i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a chunk which was not renamed from the temporary file
+	tmp := filepath.Join(tmpdir, "ffffffffffffffff.00000000"+hydra.BufferChunkSuffix+".tmp")
+	ioutil.WriteFile(tmp, []byte("incomplete"), 0644)
+
+	// reopen
+	b, err = hydra.NewFileBuffer(newConfigBuffer(tmpdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := b.Stat(); s.Chunks != len(tags) {
+		t.Errorf("unexpected chunks %d", s.Chunks)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temporary file must be removed %s", err)
+	}
+	b.Close()
+	for i, tag := range tags {
+		chunk, err := b.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk == nil {
+			t.Fatal("chunk must not be nil")
+		}
+		if chunk.Tag != tag || string(chunk.Entries) != tag || chunk.Messages != i+1 {
+			t.Errorf("unexpected chunk %#v", chunk)
+		}
+		b.Remove(chunk)
+	}
+	if chunk, _ := b.Peek(); chunk != nil {
+		t.Errorf("buffer must be empty %#v", chunk)
+	}
+	if s := b.Stat(); s.Chunks != 0 || s.Bytes != 0 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+}
+
+func TestFileBufferLimits(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	config := newConfigBuffer(tmpdir)
+	config.MaxBytes = 50
+	b, err := hydra.NewFileBuffer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"test.a", "test.b", "test.c"} {
+		b.Push(tag, []byte("0123456789"), 1)
+	}
+	s := b.Stat()
+	if s.Chunks != 2 || s.Disposed != 1 {
+		t.Errorf("oldest chunk must be disposed by MaxBytes %#v", s)
+	}
+	if chunk, _ := b.Peek(); chunk.Tag != "test.b" {
+		t.Errorf("unexpected chunk %s", chunk.Tag)
+	}
+
+	config.MaxBytes = 0
+	config.MaxAge = hydra.Duration{Duration: 500 * time.Millisecond}
+	b, err = hydra.NewFileBuffer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1 * time.Second)
+	b.Push("test.d", []byte("0123456789"), 1)
+	s = b.Stat()
+	if s.Chunks != 1 || s.Disposed != 2 {
+		t.Errorf("expired chunks must be disposed by MaxAge %#v", s)
+	}
+}
+
+func TestFileBufferPushWhileSending(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	config := newConfigBuffer(tmpdir)
+	config.MaxBytes = 50
+	config.MaxAge = hydra.Duration{Duration: 500 * time.Millisecond}
+	b, err := hydra.NewFileBuffer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Push("test.a", []byte("0123456789"), 1)
+	b.Push("test.b", []byte("0123456789"), 1)
+	sending, _ := b.Peek()
+	if sending.Tag != "test.a" {
+		t.Fatalf("unexpected chunk %s", sending.Tag)
+	}
+
+	// pushed while sending test.a is blocked
+	b.Push("test.c", []byte("0123456789"), 1)
+	time.Sleep(1 * time.Second)
+	b.Push("test.d", []byte("0123456789"), 1)
+	s := b.Stat()
+	if s.Chunks != 2 || s.Disposed != 2 {
+		t.Errorf("chunks except the sending one must be disposed %#v", s)
+	}
+	if chunk, _ := b.Peek(); chunk != sending {
+		t.Errorf("sending chunk must not be disposed %s", chunk.Tag)
+	}
+	if files, _ := filepath.Glob(filepath.Join(tmpdir, "*"+hydra.BufferChunkSuffix)); len(files) != 2 {
+		t.Errorf("unexpected chunk files %v", files)
+	}
+
+	b.Remove(sending)
+	if chunk, _ := b.Peek(); chunk.Tag != "test.d" {
+		t.Errorf("unexpected chunk %s", chunk.Tag)
+	}
+	if s := b.Stat(); s.Chunks != 1 || s.Disposed != 2 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+}
+
+func TestForwardBuffered(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	// reserve an address which nobody listens
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	// all servers are down. record sets are stored in the buffer.
+	buffer, err := hydra.NewFileBuffer(newConfigBuffer(tmpdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{newConfigServer(addr)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outForward.Buffer = buffer
+	c.RunProcess(outForward)
+	for i := 0; i < 3; i++ {
+		c.MessageCh <- prepareRecordSet()
+	}
+	c.Shutdown()
+
+	// restart with a live server. buffered chunks are replayed.
+	rc, inForward := runAuthReceiver(t, hydra.ConfigAuth{})
+	buffer, err = hydra.NewFileBuffer(newConfigBuffer(tmpdir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := buffer.Stat(); s.Chunks != 3 {
+		t.Errorf("buffered chunks %d expected %d", s.Chunks, 3)
+	}
+	c = hydra.NewContext()
+	outForward, err = hydra.NewOutForward([]*hydra.ConfigServer{newConfigServer(inForward.Addr.String())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outForward.Buffer = buffer
+	c.RunProcess(outForward)
+
+	if n := countMessages(rc.MessageCh, time.Second); n != len(TestMessageLines)*3 {
+		t.Errorf("arrived messages %d expected %d", n, len(TestMessageLines)*3)
+	}
+	c.Shutdown()
+	if s := buffer.Stat(); s.Chunks != 0 {
+		t.Errorf("buffered chunks %d expected %d", s.Chunks, 0)
+	}
+}
@@ -17,6 +17,9 @@ const (
 	FormatLTSV
 	FormatJSON
 	FormatRegexp
+	FormatCSV
+	FormatTSV
+	FormatLogfmt
 )
 
 const (
@@ -41,6 +44,8 @@ type TimeConverter TimeFormat
 
 type Regexp struct {
 	*regexp.Regexp
+	grok  string
+	types map[string]string // types of fields in the grok expression
 }
 
 var (
@@ -115,6 +120,16 @@ type RecordModifier struct {
 	timeConverter TimeConverter
 }
 
+// NewRecordModifier creates a RecordModifier from Types, TimeParse, TimeKey and TimeFormat (of [[Logs]] or [[Exec]]).
+func NewRecordModifier(convertMap ConvertMap, timeParse bool, timeKey string, timeFormat TimeFormat) *RecordModifier {
+	return &RecordModifier{
+		convertMap:    convertMap,
+		timeParse:     timeParse,
+		timeKey:       timeKey,
+		timeConverter: TimeConverter(timeFormat),
+	}
+}
+
 func (m *RecordModifier) Modify(r *fluent.TinyFluentRecord) {
 	if m.convertMap.ConverterMap != nil {
 		m.convertMap.ConvertTypes(r.Data)
@@ -139,6 +154,12 @@ func (f *FileFormat) UnmarshalText(text []byte) error {
 		*f = FormatJSON
 	case "regexp":
 		*f = FormatRegexp
+	case "csv":
+		*f = FormatCSV
+	case "tsv":
+		*f = FormatTSV
+	case "logfmt":
+		*f = FormatLogfmt
 	case "", "none":
 		*f = FormatNone
 	default:
@@ -160,6 +181,17 @@ func (r *Regexp) UnmarshalText(text []byte) error {
 	case "syslog":
 		r.Regexp = RegexpSyslog
 	default:
+		if IsGrok(s) {
+			// compiled again by Config.CompileGrok with GrokPatternFiles
+			r.grok = s
+			g := NewGrok()
+			if !g.hasPatterns(s) {
+				// custom patterns are defined in GrokPatternFiles
+				return nil
+			}
+			r.Regexp, r.types, err = g.compile(s)
+			return err
+		}
 		r.Regexp, err = regexp.Compile(s)
 	}
 	return err
@@ -171,31 +203,51 @@ func (c *ConvertMap) UnmarshalText(text []byte) error {
 }
 
 func NewConvertMap(config string) ConvertMap {
-	var m ConvertMap
-	m.TypeMap = make(map[string]ConvertType)
-	m.ConverterMap = make(map[string]Converter)
+	m := ConvertMap{
+		TypeMap:      make(map[string]ConvertType),
+		ConverterMap: make(map[string]Converter),
+	}
 	for _, subdef := range strings.Split(config, ",") {
 		def := strings.SplitN(subdef, ":", 2)
 		if len(def) < 2 {
 			continue
 		}
-		key := def[0]
-		switch def[1] {
-		case "bool":
-			m.TypeMap[key] = ConvertTypeBool
-			m.ConverterMap[key] = convertBool
-		case "integer":
-			m.TypeMap[key] = ConvertTypeInt
-			m.ConverterMap[key] = convertInt
-		case "float":
-			m.TypeMap[key] = ConvertTypeFloat
-			m.ConverterMap[key] = convertFloat
-		default:
-		}
+		m.set(def[0], def[1])
 	}
 	return m
 }
 
+func (c *ConvertMap) set(key, typ string) {
+	if c.TypeMap == nil {
+		c.TypeMap = make(map[string]ConvertType)
+		c.ConverterMap = make(map[string]Converter)
+	}
+	switch typ {
+	case "bool":
+		c.TypeMap[key] = ConvertTypeBool
+		c.ConverterMap[key] = convertBool
+	case "integer":
+		c.TypeMap[key] = ConvertTypeInt
+		c.ConverterMap[key] = convertInt
+	case "float":
+		c.TypeMap[key] = ConvertTypeFloat
+		c.ConverterMap[key] = convertFloat
+	default:
+	}
+}
+
+// addGrokTypes adds types of fields in the grok expression of r. Types take precedence.
+func (c *ConvertMap) addGrokTypes(r *Regexp) {
+	if r == nil {
+		return
+	}
+	for key, typ := range r.types {
+		if _, ok := c.TypeMap[key]; !ok {
+			c.set(key, typ)
+		}
+	}
+}
+
 func (c ConvertMap) ConvertTypes(data map[string]interface{}) {
 	for key, converter := range c.ConverterMap {
 		if _value, ok := data[key]; ok {
@@ -4,9 +4,9 @@ package hydra
 
 import "fmt"
 
-const _FileFormat_name = "FormatNoneFormatLTSVFormatJSONFormatRegexp"
+const _FileFormat_name = "FormatNoneFormatLTSVFormatJSONFormatRegexpFormatCSVFormatTSVFormatLogfmt"
 
-var _FileFormat_index = [...]uint8{0, 10, 20, 30, 42}
+var _FileFormat_index = [...]uint8{0, 10, 20, 30, 42, 51, 60, 72}
 
 func (i FileFormat) String() string {
 	if i < 0 || i >= FileFormat(len(_FileFormat_index)-1) {
@@ -0,0 +1,331 @@
+package hydra
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const (
+	FilterTypeGrep       = "grep"
+	FilterTypeTransform  = "transform"
+	FilterTypeRewriteTag = "rewrite_tag"
+)
+
+// Filter processes a record set before it is sent to outputs.
+// It returns record sets to be passed (may be split by tags), or nil when all records were dropped.
+type Filter interface {
+	Filter(rs *fluent.FluentRecordSet, monitorCh chan Stat) []*fluent.FluentRecordSet
+}
+
+// Filters is a pipeline of filters.
+type Filters []Filter
+
+// Apply applies filters in order. It returns nil when all records were dropped.
+func (fs Filters) Apply(rs *fluent.FluentRecordSet, monitorCh chan Stat) []*fluent.FluentRecordSet {
+	sets := []*fluent.FluentRecordSet{rs}
+	for _, f := range fs {
+		passed := make([]*fluent.FluentRecordSet, 0, len(sets))
+		for _, rs := range sets {
+			for _, r := range f.Filter(rs, monitorCh) {
+				if len(r.Records) > 0 {
+					passed = append(passed, r)
+				}
+			}
+		}
+		if len(passed) == 0 {
+			return nil
+		}
+		sets = passed
+	}
+	return sets
+}
+
+// GrepFilter keeps records which have the key matching the pattern, or drops them when Exclude.
+type GrepFilter struct {
+	name       string
+	tagPattern *TagPattern
+	key        string
+	pattern    *Regexp
+	exclude    bool
+}
+
+func NewGrepFilter(config *ConfigGrep) (*GrepFilter, error) {
+	if config.Key == "" {
+		return nil, fmt.Errorf("Key is required for Grep")
+	}
+	if config.Pattern == nil || config.Pattern.Regexp == nil {
+		return nil, fmt.Errorf("Pattern is required for Grep")
+	}
+	g := &GrepFilter{
+		name:       config.Name,
+		tagPattern: config.TagPattern,
+		key:        config.Key,
+		pattern:    config.Pattern,
+		exclude:    config.Exclude,
+	}
+	if g.name == "" {
+		op := "=~"
+		if g.exclude {
+			op = "!~"
+		}
+		g.name = fmt.Sprintf("grep %s %s /%s/", g.key, op, g.pattern)
+	}
+	return g, nil
+}
+
+func (g *GrepFilter) Filter(rs *fluent.FluentRecordSet, monitorCh chan Stat) []*fluent.FluentRecordSet {
+	if !g.tagPattern.Match(rs.Tag) {
+		return []*fluent.FluentRecordSet{rs}
+	}
+	records := make([]fluent.FluentRecordType, 0, len(rs.Records))
+	for _, r := range rs.Records {
+		if g.match(r) != g.exclude {
+			records = append(records, r)
+		}
+	}
+	monitorCh <- &FilterStat{
+		Name:    g.name,
+		Type:    FilterTypeGrep,
+		Passed:  int64(len(records)),
+		Dropped: int64(len(rs.Records) - len(records)),
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	rs.Records = records
+	return []*fluent.FluentRecordSet{rs}
+}
+
+func (g *GrepFilter) match(r fluent.FluentRecordType) bool {
+	v, ok := r.GetData(g.key)
+	if !ok {
+		return false
+	}
+	return g.pattern.MatchString(recordValueString(v))
+}
+
+// TransformFilter renames, adds and removes fields of records (in this order).
+// Values of AddFields may contain ${hostname}, ${tag} and ${file}.
+type TransformFilter struct {
+	name         string
+	tagPattern   *TagPattern
+	renameFields map[string]string
+	addFields    map[string]string
+	removeFields []string
+}
+
+// NewTransformFilter creates a TransformFilter for records read from the file ("" for others).
+func NewTransformFilter(config *ConfigTransform, file string) (*TransformFilter, error) {
+	// renames are applied in random order of the map, so they must not depend on each other
+	targets := make(map[string]string, len(config.RenameFields))
+	for from, to := range config.RenameFields {
+		if _, ok := config.RenameFields[to]; ok {
+			return nil, fmt.Errorf("RenameFields %s -> %s conflicts with the rename of %s", from, to, to)
+		}
+		if other, ok := targets[to]; ok {
+			return nil, fmt.Errorf("RenameFields %s and %s are renamed to the same %s", other, from, to)
+		}
+		targets[to] = from
+	}
+	hostname := config.hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	// ${tag} is expanded for each record set
+	r := strings.NewReplacer("${hostname}", hostname, "${file}", file)
+	f := &TransformFilter{
+		name:         config.Name,
+		tagPattern:   config.TagPattern,
+		renameFields: config.RenameFields,
+		addFields:    make(map[string]string, len(config.AddFields)),
+		removeFields: config.RemoveFields,
+	}
+	for key, value := range config.AddFields {
+		f.addFields[key] = r.Replace(value)
+	}
+	if f.name == "" {
+		f.name = FilterTypeTransform
+	}
+	return f, nil
+}
+
+func (f *TransformFilter) Filter(rs *fluent.FluentRecordSet, monitorCh chan Stat) []*fluent.FluentRecordSet {
+	if !f.tagPattern.Match(rs.Tag) {
+		return []*fluent.FluentRecordSet{rs}
+	}
+	r := strings.NewReplacer("${tag}", rs.Tag)
+	for i, record := range rs.Records {
+		data := promoteRecord(record)
+		rs.Records[i] = data
+		for from, to := range f.renameFields {
+			if v, ok := data.Data[from]; ok {
+				delete(data.Data, from)
+				data.Data[to] = v
+			}
+		}
+		for key, value := range f.addFields {
+			data.Data[key] = r.Replace(value)
+		}
+		for _, key := range f.removeFields {
+			delete(data.Data, key)
+		}
+	}
+	monitorCh <- &FilterStat{
+		Name:   f.name,
+		Type:   FilterTypeTransform,
+		Passed: int64(len(rs.Records)),
+	}
+	return []*fluent.FluentRecordSet{rs}
+}
+
+// RewriteTagFilter rewrites tags of records by the Tag template.
+// ${tag}, ${N} (a submatch of Pattern), ${name} (a named submatch or a field of the record) are expanded.
+// Records which don't match Pattern or lack fields in the template keep the original tag.
+type RewriteTagFilter struct {
+	name       string
+	tagPattern *TagPattern
+	key        string
+	pattern    *Regexp
+	tag        string
+}
+
+var tagPlaceholder = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+func NewRewriteTagFilter(config *ConfigRewriteTag) (*RewriteTagFilter, error) {
+	if config.Tag == "" {
+		return nil, fmt.Errorf("Tag is required for RewriteTag")
+	}
+	if config.Pattern != nil && config.Key == "" {
+		return nil, fmt.Errorf("Key is required for RewriteTag with Pattern")
+	}
+	f := &RewriteTagFilter{
+		name:       config.Name,
+		tagPattern: config.TagPattern,
+		key:        config.Key,
+		pattern:    config.Pattern,
+		tag:        config.Tag,
+	}
+	if f.pattern != nil && f.pattern.Regexp == nil {
+		return nil, fmt.Errorf("Pattern is not compiled for RewriteTag")
+	}
+	if f.name == "" {
+		f.name = "rewrite_tag " + f.tag
+	}
+	return f, nil
+}
+
+func (f *RewriteTagFilter) Filter(rs *fluent.FluentRecordSet, monitorCh chan Stat) []*fluent.FluentRecordSet {
+	if !f.tagPattern.Match(rs.Tag) {
+		return []*fluent.FluentRecordSet{rs}
+	}
+	sets := make([]*fluent.FluentRecordSet, 0, 1)
+	index := make(map[string]*fluent.FluentRecordSet)
+	rewritten := 0
+	for _, r := range rs.Records {
+		tag, ok := f.rewrite(rs.Tag, r)
+		if ok {
+			rewritten++
+		} else {
+			tag = rs.Tag
+		}
+		set, exists := index[tag]
+		if !exists {
+			set = &fluent.FluentRecordSet{Tag: tag}
+			index[tag] = set
+			sets = append(sets, set)
+		}
+		set.Records = append(set.Records, r)
+	}
+	monitorCh <- &FilterStat{
+		Name:      f.name,
+		Type:      FilterTypeRewriteTag,
+		Passed:    int64(len(rs.Records)),
+		Rewritten: int64(rewritten),
+	}
+	return sets
+}
+
+// rewrite returns the new tag of the record, or false when the record is not rewritten.
+func (f *RewriteTagFilter) rewrite(tag string, r fluent.FluentRecordType) (string, bool) {
+	var submatches []string
+	var names []string
+	if f.pattern != nil {
+		v, ok := r.GetData(f.key)
+		if !ok {
+			return "", false
+		}
+		submatches = f.pattern.FindStringSubmatch(recordValueString(v))
+		if submatches == nil {
+			return "", false
+		}
+		names = f.pattern.SubexpNames()
+	}
+	ok := true
+	newTag := tagPlaceholder.ReplaceAllStringFunc(f.tag, func(s string) string {
+		name := s[2 : len(s)-1]
+		if name == "tag" {
+			return tag
+		}
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < len(submatches) {
+				return submatches[n]
+			}
+			ok = false
+			return ""
+		}
+		for i, subname := range names {
+			if subname == name {
+				return submatches[i]
+			}
+		}
+		if v, exists := r.GetData(name); exists {
+			return recordValueString(v)
+		}
+		ok = false
+		return ""
+	})
+	if !ok || newTag == "" {
+		return "", false
+	}
+	return newTag, true
+}
+
+func recordValueString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// promoteRecord converts a record into a TinyFluentRecord to modify its fields.
+func promoteRecord(record fluent.FluentRecordType) *fluent.TinyFluentRecord {
+	switch r := record.(type) {
+	case *fluent.TinyFluentRecord:
+		return r
+	case *fluent.TinyFluentMessage:
+		return &fluent.TinyFluentRecord{
+			Timestamp: r.Timestamp,
+			Data:      map[string]interface{}{r.FieldName: string(r.Message)},
+		}
+	case *fluent.FluentRecord:
+		return &fluent.TinyFluentRecord{
+			Timestamp: r.Timestamp,
+			Data:      r.Data,
+		}
+	default:
+		return &fluent.TinyFluentRecord{
+			Timestamp: time.Now(),
+			Data:      record.GetAllData(),
+		}
+	}
+}
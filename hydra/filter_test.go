@@ -0,0 +1,288 @@
+package hydra_test
+
+import (
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+	client "github.com/t-k/fluent-logger-golang/fluent"
+)
+
+func newGrepFilter(t *testing.T, config *hydra.ConfigGrep) *hydra.GrepFilter {
+	f, err := hydra.NewGrepFilter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func newFilterTestRecordSet(tag string, paths ...string) *fluent.FluentRecordSet {
+	rs := &fluent.FluentRecordSet{Tag: tag}
+	for _, path := range paths {
+		rs.Records = append(rs.Records, &fluent.TinyFluentRecord{
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"path": path},
+		})
+	}
+	return rs
+}
+
+func TestGrepFilter(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	tp, _ := hydra.NewTagPattern("access.**")
+	filters := hydra.Filters{
+		newGrepFilter(t, &hydra.ConfigGrep{
+			Name:    "api",
+			Key:     "path",
+			Pattern: &hydra.Regexp{Regexp: regexp.MustCompile(`^/api/`)},
+		}),
+		newGrepFilter(t, &hydra.ConfigGrep{
+			Name:       "health",
+			TagPattern: tp,
+			Key:        "path",
+			Pattern:    &hydra.Regexp{Regexp: regexp.MustCompile(`/health$`)},
+			Exclude:    true,
+		}),
+	}
+
+	sets := filters.Apply(newFilterTestRecordSet("access.nginx", "/api/users", "/", "/api/health", "/api/items"), monitorCh)
+	if len(sets) != 1 || len(sets[0].Records) != 2 {
+		t.Fatalf("unexpected record sets %#v", sets)
+	}
+	rs := sets[0]
+	for i, path := range []string{"/api/users", "/api/items"} {
+		if v, _ := rs.Records[i].GetData("path"); v != path {
+			t.Errorf("unexpected record %#v", rs.Records[i])
+		}
+	}
+
+	// health filter is not applied to other tags
+	sets = filters.Apply(newFilterTestRecordSet("app", "/api/health"), monitorCh)
+	if len(sets) != 1 || len(sets[0].Records) != 1 {
+		t.Errorf("unexpected record sets %#v", sets)
+	}
+
+	// all records are dropped
+	if sets := filters.Apply(newFilterTestRecordSet("app", "/"), monitorCh); sets != nil {
+		t.Errorf("unexpected record sets %#v", sets)
+	}
+
+	stats := &hydra.Stats{}
+	close(monitorCh)
+	for s := range monitorCh {
+		s.ApplyTo(stats)
+	}
+	if s := stats.Filters["api"]; s == nil || s.Type != "grep" || s.Passed != 4 || s.Dropped != 2 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+	if s := stats.Filters["health"]; s == nil || s.Passed != 2 || s.Dropped != 1 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+}
+
+func TestGrepFilterMessage(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	f := newGrepFilter(t, &hydra.ConfigGrep{
+		Key:     "message",
+		Pattern: &hydra.Regexp{Regexp: regexp.MustCompile(`DEBUG`)},
+		Exclude: true,
+	})
+	rs := hydra.NewFluentRecordSet("app", "message", hydra.FormatNone, nil, nil, []byte("INFO start\nDEBUG foo\nINFO end"))
+	if sets := f.Filter(rs, monitorCh); len(sets) != 1 || len(sets[0].Records) != 2 {
+		t.Errorf("unexpected record sets %#v", sets)
+	}
+	s := (<-monitorCh).(*hydra.FilterStat)
+	if s.Name != "grep message !~ /DEBUG/" || s.Dropped != 1 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+	if _, err := hydra.NewGrepFilter(&hydra.ConfigGrep{Key: "message"}); err == nil {
+		t.Error("Pattern must be required")
+	}
+}
+
+func TestInForwardGrep(t *testing.T) {
+	tp, _ := hydra.NewTagPattern("debug.**")
+	config := &hydra.ConfigReceiver{
+		Host:              "127.0.0.1",
+		MaxBufferMessages: 1000,
+		ConfigFilters: hydra.ConfigFilters{
+			Grep: []*hydra.ConfigGrep{
+				{
+					TagPattern: tp,
+					Key:        "level",
+					Pattern:    &hydra.Regexp{Regexp: regexp.MustCompile(`^(?:warn|error)$`)},
+				},
+			},
+		},
+	}
+	c := hydra.NewContext()
+	inForward, err := hydra.NewInForward(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inForward)
+
+	host, _port, _ := net.SplitHostPort(inForward.Addr.String())
+	port, _ := strconv.Atoi(_port)
+	logger, err := client.New(client.Config{FluentHost: host, FluentPort: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	for _, level := range []string{"info", "warn", "debug", "error"} {
+		logger.Post("debug.app", map[string]interface{}{"level": level})
+		logger.Post("app", map[string]interface{}{"level": level})
+	}
+
+	tags := map[string]int{}
+	for _, rs := range receiveRecordSets(c.MessageCh, 8) {
+		tags[rs.Tag] += len(rs.Records)
+	}
+	if tags["debug.app"] != 2 || tags["app"] != 4 {
+		t.Errorf("unexpected records %#v", tags)
+	}
+}
+
+func TestTransformFilter(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	f, err := hydra.NewTransformFilter(&hydra.ConfigTransform{
+		Name:         "stamp",
+		RenameFields: map[string]string{"msg": "message", "lvl": "level"},
+		AddFields:    map[string]string{"source": "${hostname}:${file}", "tag": "${tag}", "env": "production"},
+		RemoveFields: []string{"password", "env_secret"},
+	}, "/var/log/app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	rs := &fluent.FluentRecordSet{
+		Tag: "app.access",
+		Records: []fluent.FluentRecordType{
+			&fluent.TinyFluentRecord{
+				Data: map[string]interface{}{"msg": "hello", "password": "secret", "id": 1},
+			},
+			&fluent.TinyFluentMessage{
+				Timestamp: time.Unix(1451606400, 0),
+				FieldName: "msg",
+				Message:   []byte("raw line"),
+			},
+		},
+	}
+	sets := f.Filter(rs, monitorCh)
+	if len(sets) != 1 {
+		t.Fatalf("unexpected record sets %#v", sets)
+	}
+	rs = sets[0]
+	expected := []map[string]interface{}{
+		{"message": "hello", "id": 1, "source": hostname + ":/var/log/app.log", "tag": "app.access", "env": "production"},
+		{"message": "raw line", "source": hostname + ":/var/log/app.log", "tag": "app.access", "env": "production"},
+	}
+	for i, r := range rs.Records {
+		data := r.GetAllData()
+		if len(data) != len(expected[i]) {
+			t.Errorf("records[%d] unexpected data %#v", i, data)
+		}
+		for k, v := range expected[i] {
+			if data[k] != v {
+				t.Errorf("records[%d] %s expected %#v got %#v", i, k, v, data[k])
+			}
+		}
+	}
+	if r := rs.Records[1].(*fluent.TinyFluentRecord); !r.Timestamp.Equal(time.Unix(1451606400, 0)) {
+		t.Errorf("timestamp of the message must be kept %s", r.Timestamp)
+	}
+	if s := (<-monitorCh).(*hydra.FilterStat); s.Name != "stamp" || s.Type != "transform" || s.Passed != 2 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+	// chained or merged renames are ambiguous
+	for _, renames := range []map[string]string{
+		{"a": "b", "b": "c"},
+		{"a": "b", "b": "a"},
+		{"a": "c", "b": "c"},
+	} {
+		if _, err := hydra.NewTransformFilter(&hydra.ConfigTransform{RenameFields: renames}, ""); err == nil {
+			t.Errorf("RenameFields %v must be an error", renames)
+		}
+	}
+}
+
+func TestRewriteTagFilter(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	f, err := hydra.NewRewriteTagFilter(&hydra.ConfigRewriteTag{
+		Name:    "status",
+		Key:     "status",
+		Pattern: &hydra.Regexp{Regexp: regexp.MustCompile(`^(?P<status_class>[1-5])\d\d$`)},
+		Tag:     "${tag}.${status_class}xx.${method}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &fluent.FluentRecordSet{Tag: "app"}
+	for _, data := range []map[string]interface{}{
+		{"status": "200", "method": "GET"},
+		{"status": 503, "method": "GET"},
+		{"status": "204", "method": "GET"},
+		{"status": "-", "method": "GET"},
+		{"status": "404"}, // lacks method
+		{"status": "503", "method": "GET"},
+	} {
+		rs.Records = append(rs.Records, &fluent.TinyFluentRecord{Data: data})
+	}
+	sets := f.Filter(rs, monitorCh)
+	expected := []struct {
+		tag     string
+		records int
+	}{
+		{"app.2xx.GET", 2},
+		{"app.5xx.GET", 2},
+		{"app", 2},
+	}
+	if len(sets) != len(expected) {
+		t.Fatalf("unexpected record sets %#v", sets)
+	}
+	for i, e := range expected {
+		if sets[i].Tag != e.tag || len(sets[i].Records) != e.records {
+			t.Errorf("sets[%d] expected %s %d records got %s %d records", i, e.tag, e.records, sets[i].Tag, len(sets[i].Records))
+		}
+	}
+	if s := (<-monitorCh).(*hydra.FilterStat); s.Name != "status" || s.Type != "rewrite_tag" || s.Passed != 6 || s.Rewritten != 4 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+
+	if _, err := hydra.NewRewriteTagFilter(&hydra.ConfigRewriteTag{Key: "status"}); err == nil {
+		t.Error("Tag must be required")
+	}
+	if _, err := hydra.NewRewriteTagFilter(&hydra.ConfigRewriteTag{Key: "status", Pattern: &hydra.Regexp{}, Tag: "app"}); err == nil {
+		t.Error("Pattern not compiled must be an error")
+	}
+}
+
+func TestRewriteTagFilterCaptures(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	tp, _ := hydra.NewTagPattern("syslog.**")
+	f, err := hydra.NewRewriteTagFilter(&hydra.ConfigRewriteTag{
+		TagPattern: tp,
+		Key:        "message",
+		Pattern:    &hydra.Regexp{Regexp: regexp.MustCompile(`^(\w+)\[\d+\]:`)},
+		Tag:        "audit.${1}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := hydra.NewFluentRecordSet("syslog.auth", "message", hydra.FormatNone, nil, nil, []byte("sshd[123]: accepted\nkernel: oops"))
+	sets := hydra.Filters{f}.Apply(rs, monitorCh)
+	if len(sets) != 2 || sets[0].Tag != "audit.sshd" || sets[1].Tag != "syslog.auth" {
+		t.Errorf("unexpected record sets %#v", sets)
+	}
+
+	// not applied to other tags
+	rs = hydra.NewFluentRecordSet("app", "message", hydra.FormatNone, nil, nil, []byte("sshd[123]: accepted"))
+	if sets := f.Filter(rs, monitorCh); len(sets) != 1 || sets[0].Tag != "app" {
+		t.Errorf("unexpected record sets %#v", sets)
+	}
+}
@@ -0,0 +1,217 @@
+package hydra
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const grokMaxDepth = 32
+
+// GrokPatterns is the built-in library of grok patterns (compatible with Logstash's, written in RE2 syntax).
+var GrokPatterns = map[string]string{
+	"USERNAME":       `[a-zA-Z0-9._-]+`,
+	"USER":           `%{USERNAME}`,
+	"EMAILLOCALPART": `[a-zA-Z0-9!#$%&'*+\-/=?^_{|}~]+(?:\.[a-zA-Z0-9!#$%&'*+\-/=?^_{|}~]+)*`,
+	"EMAILADDRESS":   `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":            `[+-]?[0-9]+`,
+	"BASE10NUM":      `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":         `%{BASE10NUM}`,
+	"BASE16NUM":      `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":         `[1-9][0-9]*`,
+	"NONNEGINT":      `[0-9]+`,
+	"WORD":           `\b\w+\b`,
+	"NOTSPACE":       `\S+`,
+	"SPACE":          `\s*`,
+	"DATA":           `.*?`,
+	"GREEDYDATA":     `.*`,
+	"QUOTEDSTRING":   `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":             `%{QUOTEDSTRING}`,
+	"UUID":           `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	"MAC":        `%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC}`,
+	"CISCOMAC":   `(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4}`,
+	"WINDOWSMAC": `(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2}`,
+	"COMMONMAC":  `(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2}`,
+	"IPV4":       `(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`,
+	"IPV6":       `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:[0-9A-Fa-f]{1,4}|%{IPV4})?`,
+	"IP":         `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":   `\b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*\.?\b`,
+	"IPORHOST":   `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":   `%{IPORHOST}:%{POSINT}`,
+
+	"UNIXPATH":     `(?:/[\w_%!$@:.,+~-]*)+`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"PATH":         `%{UNIXPATH}|%{WINPATH}`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+\-.]*`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary|uar)?|[Ff]eb(?:ruary|ruar)?|[Mm](?:a|ä)?r(?:ch|z)?|[Aa]pr(?:il)?|[Mm]a(?:y|i)?|[Jj]un(?:e|i)?|[Jj]ul(?:y|i)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo](?:c|k)?t(?:ober)?|[Nn]ov(?:ember)?|[Dd]e(?:c|z)(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `(?:0[1-9])|(?:[12][0-9])|(?:3[01])|[1-9]`,
+	"DAY":               `(?:Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?)`,
+	"YEAR":              `[0-9]{4}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"DATE":              `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":         `%{DATE}[- ]%{TIME}`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	"PROG":              `[\x21-\x5a\x5c\x5e-\x7e]+`,
+	"SYSLOGPROG":        `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST":        `%{IPORHOST}`,
+	"SYSLOGBASE":        `%{SYSLOGTIMESTAMP:timestamp} %{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn?(?:ing)?|WARN?(?:ING)?|[Ee]rr?(?:or)?|ERR?(?:OR)?|[Cc]rit?(?:ical)?|CRIT?(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|EMERG(?:ENCY)?|[Ee]merg(?:ency)?`,
+	"HTTPDUSER":         `%{EMAILADDRESS}|%{USER}`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{HTTPDUSER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::(\w+))?(?::(\w+))?\}`)
+
+// Grok compiles grok expressions like `%{IP:client} %{WORD:method}` into regexps.
+// %{NAME:field} is captured as a named group "field", %{NAME} is not captured.
+// %{NAME:field:int} (or float) also converts the type of the field.
+type Grok struct {
+	patterns map[string]string
+}
+
+// NewGrok returns a Grok which has the built-in patterns.
+func NewGrok() *Grok {
+	g := &Grok{patterns: make(map[string]string, len(GrokPatterns))}
+	for name, pattern := range GrokPatterns {
+		g.patterns[name] = pattern
+	}
+	return g
+}
+
+func (g *Grok) AddPattern(name, pattern string) {
+	g.patterns[name] = pattern
+}
+
+// LoadPatternFile loads patterns from a file which has "NAME pattern" lines (Logstash's patterns file format).
+func (g *Grok) LoadPatternFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pair := strings.SplitN(line, " ", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("%s:%d invalid grok pattern definition", filename, n)
+		}
+		g.AddPattern(pair[0], strings.TrimSpace(pair[1]))
+	}
+	return scanner.Err()
+}
+
+// Compile expands the grok expression and compiles it.
+func (g *Grok) Compile(expr string) (*regexp.Regexp, error) {
+	reg, _, err := g.compile(expr)
+	return reg, err
+}
+
+// compile returns the compiled regexp and types of fields ("integer" or "float" as same as Types).
+func (g *Grok) compile(expr string) (*regexp.Regexp, map[string]string, error) {
+	types := make(map[string]string)
+	expanded, err := g.expand(expr, 0, types)
+	if err != nil {
+		return nil, nil, err
+	}
+	reg, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reg, types, nil
+}
+
+func (g *Grok) expand(expr string, depth int, types map[string]string) (string, error) {
+	if depth > grokMaxDepth {
+		return "", fmt.Errorf("grok pattern is nested too deeply (recursive?) %s", expr)
+	}
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(expr, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := grokReference.FindStringSubmatch(ref)
+		switch m[3] {
+		case "":
+		case "int":
+			types[m[2]] = "integer"
+		case "float":
+			types[m[2]] = "float"
+		default:
+			err = fmt.Errorf("unsupported type %s in grok pattern %s", m[3], ref)
+			return ""
+		}
+		pattern, ok := g.patterns[m[1]]
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %s", m[1])
+			return ""
+		}
+		var sub string
+		sub, err = g.expand(pattern, depth+1, types)
+		if m[2] != "" {
+			return "(?P<" + m[2] + ">" + sub + ")"
+		}
+		return "(?:" + sub + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(expanded, "%{") {
+		return "", fmt.Errorf("invalid grok pattern reference in %s", expr)
+	}
+	return expanded, nil
+}
+
+// CompileRegexp compiles the grok expression of r (see Regexp.UnmarshalText).
+func (g *Grok) CompileRegexp(r *Regexp) error {
+	if r == nil || r.grok == "" {
+		return nil
+	}
+	reg, types, err := g.compile(r.grok)
+	if err != nil {
+		return err
+	}
+	r.Regexp = reg
+	r.types = types
+	return nil
+}
+
+// hasPatterns returns true if all of the patterns referred in the expression are defined.
+func (g *Grok) hasPatterns(expr string) bool {
+	for _, m := range grokReference.FindAllStringSubmatch(expr, -1) {
+		if _, ok := g.patterns[m[1]]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// IsGrok returns true if the expression contains grok pattern references.
+func IsGrok(expr string) bool {
+	return grokReference.MatchString(expr)
+}
@@ -0,0 +1,106 @@
+package hydra_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestGrokCompile(t *testing.T) {
+	g := hydra.NewGrok()
+	reg, err := g.Compile(`%{IP:client} %{WORD:method} %{URIPATHPARAM:path} %{NUMBER:status} %{NOTSPACE}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := hydra.NewFluentRecordRegexp("message", []byte("192.168.0.1 GET /foo?bar=1 200 -"), &hydra.Regexp{Regexp: reg})
+	expected := map[string]interface{}{"client": "192.168.0.1", "method": "GET", "path": "/foo?bar=1", "status": "200"}
+	if len(r.Data) != len(expected) {
+		t.Errorf("unexpected record %#v", r.Data)
+	}
+	for k, v := range expected {
+		if r.Data[k] != v {
+			t.Errorf("%s expected %#v got %#v", k, v, r.Data[k])
+		}
+	}
+}
+
+func TestGrokCombinedApacheLog(t *testing.T) {
+	reg, err := hydra.NewGrok().Compile(`^%{COMBINEDAPACHELOG}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`
+	r := hydra.NewFluentRecordRegexp("message", []byte(line), &hydra.Regexp{Regexp: reg})
+	for k, v := range map[string]string{
+		"clientip":  "127.0.0.1",
+		"auth":      "frank",
+		"timestamp": "10/Oct/2000:13:55:36 -0700",
+		"verb":      "GET",
+		"request":   "/apache_pb.gif",
+		"response":  "200",
+		"bytes":     "2326",
+		"agent":     `"Mozilla/4.08"`,
+	} {
+		if r.Data[k] != v {
+			t.Errorf("%s expected %#v got %#v", k, v, r.Data[k])
+		}
+	}
+}
+
+func TestGrokErrors(t *testing.T) {
+	g := hydra.NewGrok()
+	g.AddPattern("LOOP", "a%{LOOP}")
+	for _, expr := range []string{
+		`%{UNKNOWN:foo}`,
+		`%{LOOP}`,
+		`%{INT:size:bool}`,
+		`%{INT:foo.bar}`,
+	} {
+		if _, err := g.Compile(expr); err == nil {
+			t.Errorf("%s must be an error", expr)
+		}
+	}
+}
+
+func TestGrokPatternFile(t *testing.T) {
+	g := hydra.NewGrok()
+	if err := g.LoadPatternFile("./grok_test.patterns"); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := g.Compile(`^%{APPLOG}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := reg.FindStringSubmatch("2016-01-02T03:04:05Z [WARN] disk is full")
+	if m == nil {
+		t.Fatal("must match")
+	}
+	captured := make(map[string]string)
+	for i, name := range reg.SubexpNames() {
+		captured[name] = m[i]
+	}
+	if captured["level"] != "WARN" || captured["message"] != "disk is full" {
+		t.Errorf("unexpected match %#v", m)
+	}
+}
+
+func TestRegexpUnmarshalGrok(t *testing.T) {
+	var r hydra.Regexp
+	if err := r.UnmarshalText([]byte(`%{WORD:verb} %{INT:n}`)); err != nil {
+		t.Fatal(err)
+	}
+	if r.Regexp == nil || !r.MatchString("GET 1") {
+		t.Errorf("grok must be compiled %#v", r)
+	}
+	// unknown patterns are resolved by Config.CompileGrok later
+	if err := r.UnmarshalText([]byte(`%{APPLOG}`)); err != nil {
+		t.Error(err)
+	}
+	// errors of the built-in patterns are not deferred
+	for _, expr := range []string{`%{INT:size:bool}`, `%{WORD:verb} (`} {
+		var r hydra.Regexp
+		if err := r.UnmarshalText([]byte(expr)); err == nil {
+			t.Errorf("%s must be an error", expr)
+		}
+	}
+}
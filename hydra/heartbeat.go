@@ -0,0 +1,225 @@
+package hydra
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	HeartbeatTCP                = "tcp"
+	HeartbeatUDP                = "udp"
+	DetectorPhiAccrual          = "phi"
+	DetectorFailureCount        = "count"
+	DefaultHeartbeatInterval    = 1 * time.Second
+	DefaultPhiThreshold         = 16
+	DefaultHeartbeatHardTimeout = 60 * time.Second
+	DefaultHeartbeatMaxFailures = 3
+	phiAccrualWindowSize        = 100
+	phiAccrualMinStdDev         = 100 * time.Millisecond
+)
+
+// FailureDetector decides whether a server is available from results of heartbeats.
+type FailureDetector interface {
+	Heartbeat(now time.Time)
+	Failure(now time.Time)
+	Available(now time.Time) bool
+}
+
+// PhiAccrualDetector is a phi accrual failure detector (same as fluentd's out_forward).
+type PhiAccrualDetector struct {
+	threshold   float64
+	hardTimeout time.Duration
+	last        time.Time
+	intervals   []float64
+}
+
+func NewPhiAccrualDetector(threshold float64, hardTimeout time.Duration, now time.Time) *PhiAccrualDetector {
+	return &PhiAccrualDetector{
+		threshold:   threshold,
+		hardTimeout: hardTimeout,
+		last:        now,
+		intervals:   make([]float64, 0, phiAccrualWindowSize),
+	}
+}
+
+func (d *PhiAccrualDetector) Heartbeat(now time.Time) {
+	if len(d.intervals) == phiAccrualWindowSize {
+		d.intervals = d.intervals[1:]
+	}
+	d.intervals = append(d.intervals, float64(now.Sub(d.last)))
+	d.last = now
+}
+
+func (d *PhiAccrualDetector) Failure(now time.Time) {
+}
+
+func (d *PhiAccrualDetector) Available(now time.Time) bool {
+	if now.Sub(d.last) > d.hardTimeout {
+		return false
+	}
+	return d.Phi(now) < d.threshold
+}
+
+// Phi returns suspicion level of the server at now.
+func (d *PhiAccrualDetector) Phi(now time.Time) float64 {
+	if len(d.intervals) == 0 {
+		return 0
+	}
+	var sum, sum2 float64
+	for _, v := range d.intervals {
+		sum += v
+		sum2 += v * v
+	}
+	n := float64(len(d.intervals))
+	mean := sum / n
+	stddev := math.Max(math.Sqrt(math.Max(sum2/n-mean*mean, 0)), float64(phiAccrualMinStdDev))
+
+	// logistic approximation of the cumulative normal distribution
+	t := float64(now.Sub(d.last))
+	y := (t - mean) / stddev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if t > mean {
+		return -math.Log10(e / (1.0 + e))
+	}
+	return -math.Log10(1.0 - 1.0/(1.0+e))
+}
+
+// FailureCountDetector marks a server unavailable after consecutive failures of heartbeats.
+type FailureCountDetector struct {
+	maxFailures int
+	failures    int
+}
+
+func NewFailureCountDetector(maxFailures int) *FailureCountDetector {
+	return &FailureCountDetector{maxFailures: maxFailures}
+}
+
+func (d *FailureCountDetector) Heartbeat(now time.Time) {
+	d.failures = 0
+}
+
+func (d *FailureCountDetector) Failure(now time.Time) {
+	d.failures++
+}
+
+func (d *FailureCountDetector) Available(now time.Time) bool {
+	return d.failures < d.maxFailures
+}
+
+// Heartbeater sends heartbeats to a server periodically.
+type Heartbeater struct {
+	address   string
+	typ       string
+	interval  time.Duration
+	detector  FailureDetector
+	available bool
+	received  bool // a heartbeat succeeded once at least
+	mu        sync.Mutex
+}
+
+func NewHeartbeater(config *ConfigHeartbeat, address string) (*Heartbeater, error) {
+	h := &Heartbeater{
+		address:  address,
+		typ:      config.Type,
+		interval: config.Interval.Duration,
+	}
+	switch h.typ {
+	case HeartbeatTCP, HeartbeatUDP:
+	default:
+		return nil, fmt.Errorf("Unsupported heartbeat Type %s", config.Type)
+	}
+	if h.interval == 0 {
+		h.interval = DefaultHeartbeatInterval
+	}
+	switch config.Detector {
+	case "", DetectorPhiAccrual:
+		threshold := config.PhiThreshold
+		if threshold == 0 {
+			threshold = DefaultPhiThreshold
+		}
+		hardTimeout := config.HardTimeout.Duration
+		if hardTimeout == 0 {
+			hardTimeout = DefaultHeartbeatHardTimeout
+		}
+		h.detector = NewPhiAccrualDetector(threshold, hardTimeout, time.Now())
+	case DetectorFailureCount:
+		maxFailures := config.MaxFailures
+		if maxFailures == 0 {
+			maxFailures = DefaultHeartbeatMaxFailures
+		}
+		h.detector = NewFailureCountDetector(maxFailures)
+	default:
+		return nil, fmt.Errorf("Unsupported heartbeat Detector %s", config.Detector)
+	}
+	return h, nil
+}
+
+// Available returns false when the server was detected as down,
+// or no heartbeat has succeeded yet.
+func (h *Heartbeater) Available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.available
+}
+
+func (h *Heartbeater) Run(stopCh chan interface{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.check()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Heartbeater) check() {
+	err := h.beat()
+	now := time.Now()
+	if err == nil {
+		h.detector.Heartbeat(now)
+		h.received = true
+	} else {
+		h.detector.Failure(now)
+	}
+	// a server which was never reached is not available
+	available := h.received && h.detector.Available(now)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.available != available {
+		if available {
+			log.Println("[info] Heartbeat from", h.address, "recovered")
+		} else {
+			log.Println("[warning] Heartbeat from", h.address, "lost. detected as down", err)
+		}
+	}
+	h.available = available
+}
+
+// beat sends a heartbeat compatible with fluentd. A UDP heartbeat requires a response from the server.
+// A TCP heartbeat only establishes a connection, so a server which accepts connections
+// but doesn't process them (e.g. hung fluentd) is not detected as down.
+func (h *Heartbeater) beat() error {
+	conn, err := net.DialTimeout(h.typ, h.address, h.interval)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if h.typ == HeartbeatTCP {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(h.interval))
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return err
+	}
+	buf := make([]byte, 1)
+	_, err = conn.Read(buf)
+	return err
+}
@@ -0,0 +1,164 @@
+package hydra_test
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestPhiAccrualDetector(t *testing.T) {
+	now := time.Now()
+	d := hydra.NewPhiAccrualDetector(hydra.DefaultPhiThreshold, 10*time.Second, now)
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		d.Heartbeat(now)
+	}
+	if !d.Available(now.Add(time.Second)) {
+		t.Errorf("must be available. phi %f", d.Phi(now.Add(time.Second)))
+	}
+	if d.Available(now.Add(5 * time.Second)) {
+		t.Errorf("must not be available. phi %f", d.Phi(now.Add(5*time.Second)))
+	}
+	if p1, p2 := d.Phi(now.Add(1500*time.Millisecond)), d.Phi(now.Add(2*time.Second)); p1 >= p2 {
+		t.Errorf("phi must increase over time %f %f", p1, p2)
+	}
+
+	// exceeds hard timeout
+	d = hydra.NewPhiAccrualDetector(hydra.DefaultPhiThreshold, 10*time.Second, now)
+	if d.Available(now.Add(11 * time.Second)) {
+		t.Error("must not be available after hard timeout")
+	}
+}
+
+func TestFailureCountDetector(t *testing.T) {
+	now := time.Now()
+	d := hydra.NewFailureCountDetector(2)
+	d.Failure(now)
+	if !d.Available(now) {
+		t.Error("must be available")
+	}
+	d.Failure(now)
+	if d.Available(now) {
+		t.Error("must not be available")
+	}
+	d.Heartbeat(now)
+	if !d.Available(now) {
+		t.Error("must be available after heartbeat")
+	}
+}
+
+func TestHeartbeaterTCP(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	l2, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := l2.Addr().String()
+	l2.Close()
+
+	config := &hydra.ConfigHeartbeat{
+		Type:        hydra.HeartbeatTCP,
+		Interval:    hydra.Duration{Duration: 100 * time.Millisecond},
+		Detector:    hydra.DetectorFailureCount,
+		MaxFailures: 2,
+	}
+	stopCh := make(chan interface{})
+	defer close(stopCh)
+	alive, err := hydra.NewHeartbeater(config, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, _ := hydra.NewHeartbeater(config, down)
+	go alive.Run(stopCh)
+	go dead.Run(stopCh)
+	time.Sleep(500 * time.Millisecond)
+	if !alive.Available() {
+		t.Error("listening server must be available")
+	}
+	if dead.Available() {
+		t.Error("down server must not be available")
+	}
+}
+
+func TestHeartbeaterNeverReached(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := l.Addr().String()
+	l.Close()
+
+	config := &hydra.ConfigHeartbeat{
+		Type:     hydra.HeartbeatTCP,
+		Interval: hydra.Duration{Duration: 100 * time.Millisecond},
+		Detector: hydra.DetectorPhiAccrual,
+	}
+	h, err := hydra.NewHeartbeater(config, down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Available() {
+		t.Error("server must not be available before the first heartbeat")
+	}
+	stopCh := make(chan interface{})
+	defer close(stopCh)
+	go h.Run(stopCh)
+	time.Sleep(500 * time.Millisecond)
+	if h.Available() {
+		t.Error("server which was never reached must not be available")
+	}
+}
+
+func TestInvalidHeartbeat(t *testing.T) {
+	if _, err := hydra.NewHeartbeater(&hydra.ConfigHeartbeat{Type: "http"}, "127.0.0.1:24224"); err == nil {
+		t.Error("invalid Type must be error")
+	}
+	if _, err := hydra.NewHeartbeater(&hydra.ConfigHeartbeat{Type: "udp", Detector: "foo"}, "127.0.0.1:24224"); err == nil {
+		t.Error("invalid Detector must be error")
+	}
+}
+
+func TestForwardHeartbeatUDP(t *testing.T) {
+	// the primary server accepts TCP connections, but does not answer heartbeats
+	primaryCounter := int64(0)
+	primaryAddr, primaryCloser := runMockServer(t, "", &primaryCounter)
+	defer close(primaryCloser)
+	rc, inForward := runAuthReceiver(t, hydra.ConfigAuth{})
+
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{
+		newConfigServer(primaryAddr),
+		newConfigServer(inForward.Addr.String()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outForward.EnableHeartbeat(&hydra.ConfigHeartbeat{
+		Type:        hydra.HeartbeatUDP,
+		Interval:    hydra.Duration{Duration: 100 * time.Millisecond},
+		Detector:    hydra.DetectorFailureCount,
+		MaxFailures: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(outForward)
+	time.Sleep(500 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		c.MessageCh <- prepareRecordSet()
+	}
+	if n := countMessages(rc.MessageCh, time.Second); n != len(TestMessageLines)*3 {
+		t.Errorf("arrived messages to secondary %d expected %d", n, len(TestMessageLines)*3)
+	}
+	if n := atomic.LoadInt64(&primaryCounter); n != 0 {
+		t.Errorf("arrived messages to primary %d expected %d", n, 0)
+	}
+	c.Shutdown()
+}
@@ -3,6 +3,7 @@ package hydra
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
 	"runtime"
 	"strings"
@@ -71,8 +72,26 @@ func (c *Context) RunProcess(p Process) {
 }
 
 func NewFluentRecordSet(tag, key string, format FileFormat, mod *RecordModifier, reg *Regexp, buffer []byte) *fluent.FluentRecordSet {
-	t := time.Now()
 	messages := bytes.Split(buffer, LineSeparator)
+	return NewFluentRecordSetMessages(tag, key, format, mod, reg, messages)
+}
+
+// NewFluentRecordSetMessages creates a FluentRecordSet from messages which were already split.
+// CSV and TSV messages are parsed with keys of the header line in the messages.
+func NewFluentRecordSetMessages(tag, key string, format FileFormat, mod *RecordModifier, reg *Regexp, messages [][]byte) *fluent.FluentRecordSet {
+	csv, _ := NewCSVParser(format, nil, "")
+	return newFluentRecordSet(tag, key, format, mod, reg, csv, messages)
+}
+
+// NewFluentRecordSetCSV creates a FluentRecordSet from CSV (or TSV) lines parsed by the parser.
+// The parser keeps the state (keys from the header line and pending lines) for following lines.
+func NewFluentRecordSetCSV(tag, key string, mod *RecordModifier, csv *CSVParser, buffer []byte) *fluent.FluentRecordSet {
+	messages := bytes.Split(buffer, LineSeparator)
+	return newFluentRecordSet(tag, key, FormatCSV, mod, nil, csv, messages)
+}
+
+func newFluentRecordSet(tag, key string, format FileFormat, mod *RecordModifier, reg *Regexp, csv *CSVParser, messages [][]byte) *fluent.FluentRecordSet {
+	t := time.Now()
 	records := make([]fluent.FluentRecordType, 0, len(messages))
 	for _, msg := range messages {
 		switch format {
@@ -101,6 +120,22 @@ func NewFluentRecordSet(tag, key string, format FileFormat, mod *RecordModifier,
 				mod.Modify(r)
 			}
 			records = append(records, r)
+		case FormatLogfmt:
+			r := NewFluentRecordLogfmt(key, msg)
+			if mod != nil {
+				mod.Modify(r)
+			}
+			records = append(records, r)
+		case FormatCSV, FormatTSV:
+			r := csv.Parse(key, msg)
+			if r == nil {
+				// header or empty line
+				continue
+			}
+			if mod != nil {
+				mod.Modify(r)
+			}
+			records = append(records, r)
 		}
 	}
 	return &fluent.FluentRecordSet{
@@ -137,6 +172,76 @@ func NewFluentRecordJSON(key string, line []byte) *fluent.TinyFluentRecord {
 	return &fluent.TinyFluentRecord{Data: data}
 }
 
+// NewFluentRecordLogfmt parses `key=value key2="quoted value" bare_key`. A bare key is set as true.
+func NewFluentRecordLogfmt(key string, line []byte) *fluent.TinyFluentRecord {
+	data := make(map[string]interface{})
+	s := string(line)
+	if !strings.Contains(s, "=") {
+		// not logfmt format.
+		data[key] = s
+		return &fluent.TinyFluentRecord{Data: data}
+	}
+	for i := 0; i < len(s); {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' && s[i] != '\t' && s[i] != '"' {
+			i++
+		}
+		name := s[start:i]
+		if name == "" || (i < len(s) && s[i] == '"') {
+			// invalid logfmt format.
+			data[key] = s
+			break
+		}
+		if i == len(s) || s[i] != '=' {
+			data[name] = true
+			continue
+		}
+		i++ // skip '='
+		if i < len(s) && s[i] == '"' {
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				// unterminated quoted value
+				data[key] = s
+				break
+			}
+			data[name] = unescapeLogfmt(s[i+1 : end])
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+			i++
+		}
+		data[name] = s[start:i]
+	}
+	return &fluent.TinyFluentRecord{Data: data}
+}
+
+// unescapeLogfmt unescapes only \" and \\ in the quoted value. Other backslashes are kept as is.
+func unescapeLogfmt(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+			i++
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
 func NewFluentRecordRegexp(key string, line []byte, r *Regexp) *fluent.TinyFluentRecord {
 	s := string(line)
 	data := make(map[string]interface{})
@@ -165,6 +270,35 @@ func Run(config *Config) *Context {
 		log.Println("[info] set ReadBufferSize", ReadBufferSize)
 	}
 
+	outputs := make([]string, 0, len(config.Outputs))
+	for _, configOutput := range config.Outputs {
+		outputs = append(outputs, configOutput.Name)
+	}
+	var router *Router
+	if routes := config.Routes(); len(routes) > 0 || len(outputs) > 1 {
+		var err error
+		router, err = NewRouter(routes, outputs)
+		if err != nil {
+			// nothing is started
+			log.Println("[error] Couldn't start routing.", err)
+			return c
+		}
+	}
+
+	outForwards := make([]*OutForward, 0, len(config.Outputs))
+	for _, configOutput := range config.Outputs {
+		outForward, err := newOutForward(config, configOutput)
+		if err != nil {
+			// nothing is started
+			log.Println("[error] Couldn't start output", configOutput.Name, err)
+			return c
+		}
+		if router != nil {
+			outForward.Input = router.Output(configOutput.Name)
+		}
+		outForwards = append(outForwards, outForward)
+	}
+
 	// start monitor server
 	monitor, err := NewMonitor(config)
 	if err != nil {
@@ -173,17 +307,13 @@ func Run(config *Config) *Context {
 		c.RunProcess(monitor)
 	}
 
-	// start out_forward
-	outForward, err := NewOutForward(config.Servers)
-	if err != nil {
-		log.Println("[error]", err)
-	} else {
-		outForward.RoundRobin = config.ServerRoundRobin
-		if outForward.RoundRobin {
-			log.Println("[info] ServerRoundRobin enabled")
-		}
+	// start out_forward for each output
+	for _, outForward := range outForwards {
 		c.RunProcess(outForward)
 	}
+	if router != nil {
+		c.RunProcess(router)
+	}
 
 	// start watcher && in_tail
 	if len(config.Logs) > 0 {
@@ -192,7 +322,12 @@ func Run(config *Config) *Context {
 			log.Println("[error]", err)
 		}
 		for _, configLogfile := range config.Logs {
-			tail, err := NewInTail(configLogfile, watcher)
+			var tail Process
+			if configLogfile.IsGlob() {
+				tail, err = NewInTailGlob(configLogfile, watcher)
+			} else {
+				tail, err = NewInTail(configLogfile, watcher)
+			}
 			if err != nil {
 				log.Println("[error]", err)
 			} else {
@@ -202,6 +337,36 @@ func Run(config *Config) *Context {
 		c.RunProcess(watcher)
 	}
 
+	// start in_syslog
+	for _, configSyslog := range config.Syslogs {
+		inSyslog, err := NewInSyslog(configSyslog)
+		if err != nil {
+			log.Println("[error]", err)
+		} else {
+			c.RunProcess(inSyslog)
+		}
+	}
+
+	// start in_http
+	if config.HTTP != nil {
+		inHTTP, err := NewInHTTP(config.HTTP)
+		if err != nil {
+			log.Println("[error]", err)
+		} else {
+			c.RunProcess(inHTTP)
+		}
+	}
+
+	// start in_exec
+	for _, configExec := range config.Execs {
+		inExec, err := NewInExec(configExec)
+		if err != nil {
+			log.Println("[error]", err)
+		} else {
+			c.RunProcess(inExec)
+		}
+	}
+
 	// start in_forward
 	if config.Receiver != nil {
 		if runtime.GOMAXPROCS(0) < 2 {
@@ -224,3 +389,45 @@ func (c *Context) Shutdown() {
 	close(c.MessageCh)
 	c.OutputProcess.Wait()
 }
+
+// newOutForward creates an out_forward of the output.
+func newOutForward(config *Config, configOutput *ConfigOutput) (*OutForward, error) {
+	name := configOutput.Name
+	outForward, err := NewOutForward(configOutput.Servers)
+	if err != nil {
+		return nil, err
+	}
+	outForward.Name = name
+	outForward.RoundRobin = configOutput.ServerRoundRobin
+	if outForward.RoundRobin {
+		log.Println("[info] ServerRoundRobin enabled. output", name)
+	}
+	log.Println("[info] Output", name, "server selection policy", outForward.Policy())
+	if config.Heartbeat != nil {
+		if err := outForward.EnableHeartbeat(config.Heartbeat); err != nil {
+			log.Println("[error] Couldn't enable heartbeat.", err)
+		} else {
+			log.Println("[info] Heartbeat enabled. type", config.Heartbeat.Type)
+		}
+	}
+	outForward.RequireAckResponse = config.RequireAckResponse
+	outForward.AckResponseTimeout = config.AckResponseTimeout.Duration
+	if outForward.RequireAckResponse {
+		log.Println("[info] RequireAckResponse enabled. timeout", outForward.AckResponseTimeout)
+	}
+	outForward.FlushInterval = config.FlushInterval.Duration
+	outForward.ChunkMaxRecords = config.ChunkMaxRecords
+	outForward.ChunkMaxBytes = config.ChunkMaxBytes
+	if outForward.FlushInterval > 0 {
+		log.Println("[info] Batching enabled. FlushInterval", outForward.FlushInterval)
+	}
+	if configOutput.Buffer != nil {
+		buffer, err := NewFileBuffer(configOutput.Buffer)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open buffer %s: %s", configOutput.Buffer.Path, err)
+		}
+		outForward.Buffer = buffer
+		log.Println("[info] Buffer enabled. path", configOutput.Buffer.Path)
+	}
+	return outForward, nil
+}
@@ -0,0 +1,248 @@
+package hydra
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const (
+	DefaultExecRestartWait = 5 * time.Second
+	execStderrMaxBytes     = 1024
+)
+
+// InExec runs a command and reads records from its stdout.
+type InExec struct {
+	tag            string
+	command        string
+	fieldName      string
+	interval       time.Duration
+	restartWait    time.Duration
+	format         FileFormat
+	regexp         *Regexp
+	csv            *CSVParser
+	recordModifier *RecordModifier
+	stat           ExecStat
+	messageCh      chan *fluent.FluentRecordSet
+	monitorCh      chan Stat
+}
+
+func NewInExec(config *ConfigExec) (*InExec, error) {
+	if config.Command == "" {
+		return nil, errors.New("Command is required for Exec")
+	}
+	if err := checkRegexps(config.Format, config.Regexp, nil); err != nil {
+		return nil, err
+	}
+	csv, err := NewCSVParser(config.Format, config.Keys, config.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	e := &InExec{
+		tag:            config.Tag,
+		command:        config.Command,
+		fieldName:      config.FieldName,
+		interval:       config.Interval.Duration,
+		restartWait:    config.RestartWait.Duration,
+		format:         config.Format,
+		regexp:         config.Regexp,
+		csv:            csv,
+		recordModifier: NewRecordModifier(config.ConvertMap, config.TimeParse, config.TimeKey, config.TimeFormat),
+		stat: ExecStat{
+			Tag:     config.Tag,
+			Command: config.Command,
+		},
+	}
+	if e.fieldName == "" {
+		e.fieldName = DefaultFieldName
+	}
+	if e.restartWait == 0 {
+		e.restartWait = DefaultExecRestartWait
+	}
+	return e, nil
+}
+
+func (e *InExec) Run(c *Context) {
+	c.InputProcess.Add(1)
+	defer c.InputProcess.Done()
+	e.messageCh = c.MessageCh
+	e.monitorCh = c.MonitorCh
+
+	c.StartProcess.Done()
+
+	if e.interval > 0 {
+		log.Println("[info] Exec", e.command, "every", e.interval, "tag:", e.tag)
+	} else {
+		log.Println("[info] Exec", e.command, "(keep alive) tag:", e.tag)
+	}
+	e.sendStat()
+	for {
+		started := time.Now()
+		e.run(c.ControlCh)
+
+		wait := e.restartWait
+		if e.interval > 0 {
+			// runs at a fixed interval from the last start
+			wait = e.interval - time.Since(started)
+		}
+		select {
+		case <-c.ControlCh:
+			log.Println("[info] shutdown in_exec", e.command)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// run runs the command once, and returns after the command exited.
+func (e *InExec) run(controlCh chan interface{}) {
+	cmd := newShellCommand(e.command)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		e.fail(err)
+		return
+	}
+	e.stat.Runs++
+
+	done := make(chan interface{})
+	defer close(done)
+	killed := false
+	var mu sync.Mutex
+	go func() {
+		select {
+		case <-controlCh:
+			mu.Lock()
+			killed = true
+			mu.Unlock()
+			cmd.Process.Kill()
+			// unblock reading when descendants of the command hold pipes
+			stdout.Close()
+			stderrPipe.Close()
+		case <-done:
+		}
+	}()
+
+	stderr := &tailBuffer{max: execStderrMaxBytes}
+	stderrDone := make(chan interface{})
+	go func() {
+		io.Copy(stderr, stderrPipe)
+		close(stderrDone)
+	}()
+
+	// each run has its own header line
+	e.readStdout(stdout, e.csv.New())
+	<-stderrDone
+	err = cmd.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if killed {
+		// results of the last completed run are kept
+		return
+	}
+	e.stat.ExitCode = exitCode(cmd)
+	e.stat.Stderr = stderr.String()
+	e.stat.Error = ""
+	if err != nil {
+		e.stat.Failures++
+		e.stat.Error = err.Error()
+		log.Printf("[warning] Exec %s failed: %s stderr: %q", e.command, err, e.stat.Stderr)
+	}
+	e.sendStat()
+}
+
+// readStdout emits lines which are available at once as a record set.
+func (e *InExec) readStdout(stdout io.Reader, csv *CSVParser) {
+	r := bufio.NewReader(stdout)
+	lines := make([][]byte, 0)
+	for {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		if len(lines) > 0 && (err != nil || r.Buffered() == 0) {
+			e.emit(lines, csv)
+			lines = make([][]byte, 0)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (e *InExec) emit(lines [][]byte, csv *CSVParser) {
+	rs := newFluentRecordSet(e.tag, e.fieldName, e.format, e.recordModifier, e.regexp, csv, lines)
+	if len(rs.Records) == 0 {
+		return
+	}
+	e.messageCh <- rs
+	e.stat.Records += int64(len(rs.Records))
+	e.sendStat()
+}
+
+func (e *InExec) fail(err error) {
+	log.Println("[error] Exec", e.command, err)
+	e.stat.Failures++
+	e.stat.Error = err.Error()
+	e.sendStat()
+}
+
+func (e *InExec) sendStat() {
+	stat := e.stat
+	e.monitorCh <- &stat
+}
+
+func newShellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// tailBuffer keeps the last max bytes written.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = b.buf[over:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	return string(b.buf)
+}
+
+// exitCode returns the exit code of the exited command, or -1.
+func exitCode(cmd *exec.Cmd) int {
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		return ws.ExitStatus()
+	}
+	return -1
+}
@@ -0,0 +1,120 @@
+package hydra_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func runInExec(t *testing.T, config *hydra.ConfigExec) *hydra.Context {
+	c := hydra.NewContext()
+	inExec, err := hydra.NewInExec(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inExec)
+	return c
+}
+
+func lastExecStat(c *hydra.Context) *hydra.ExecStat {
+	stats := &hydra.Stats{}
+	for {
+		select {
+		case s := <-c.MonitorCh:
+			s.ApplyTo(stats)
+		default:
+			for _, s := range stats.Execs {
+				return s
+			}
+			return nil
+		}
+	}
+}
+
+func TestInExecInterval(t *testing.T) {
+	c := runInExec(t, &hydra.ConfigExec{
+		Tag:        "exec",
+		Command:    `printf 'foo:1\tbar:x\nfoo:2\tbar:y\n'`,
+		Interval:   hydra.Duration{Duration: 100 * time.Millisecond},
+		Format:     hydra.FormatLTSV,
+		ConvertMap: hydra.NewConvertMap("foo:integer"),
+	})
+	recordSets := receiveRecordSets(c.MessageCh, 2)
+	c.Shutdown()
+	if len(recordSets) != 2 {
+		t.Fatalf("unexpected record sets %#v", recordSets)
+	}
+	for _, rs := range recordSets {
+		if rs.Tag != "exec" || len(rs.Records) != 2 {
+			t.Fatalf("unexpected record set %#v", rs)
+		}
+		if v, _ := rs.Records[1].GetData("foo"); v != int64(2) {
+			t.Errorf("unexpected foo %#v", v)
+		}
+		if v, _ := rs.Records[1].GetData("bar"); v != "y" {
+			t.Errorf("unexpected bar %#v", v)
+		}
+	}
+
+	stat := lastExecStat(c)
+	if stat == nil || stat.Runs < 2 || stat.Records < 4 || stat.Failures != 0 || stat.ExitCode != 0 {
+		t.Errorf("unexpected stat %#v", stat)
+	}
+}
+
+func TestInExecFailure(t *testing.T) {
+	c := runInExec(t, &hydra.ConfigExec{
+		Tag:         "exec",
+		Command:     "echo partial; echo oops >&2; exit 3",
+		RestartWait: hydra.Duration{Duration: time.Second},
+	})
+	recordSets := receiveRecordSets(c.MessageCh, 1)
+	if len(recordSets) != 1 {
+		t.Fatalf("unexpected record sets %#v", recordSets)
+	}
+	if v, _ := recordSets[0].Records[0].GetData("message"); string(v.([]byte)) != "partial" {
+		t.Errorf("unexpected message %#v", v)
+	}
+	time.Sleep(200 * time.Millisecond)
+	c.Shutdown()
+
+	stat := lastExecStat(c)
+	if stat == nil || stat.Runs != 1 || stat.Failures != 1 || stat.ExitCode != 3 || stat.Stderr != "oops\n" || stat.Error == "" {
+		t.Errorf("unexpected stat %#v", stat)
+	}
+}
+
+func TestInExecKeepAlive(t *testing.T) {
+	c := runInExec(t, &hydra.ConfigExec{
+		Tag:     "exec",
+		Command: "echo started; sleep 10",
+	})
+	recordSets := receiveRecordSets(c.MessageCh, 1)
+	if len(recordSets) != 1 {
+		t.Fatalf("unexpected record sets %#v", recordSets)
+	}
+	done := make(chan interface{})
+	go func() {
+		c.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown timed out while the command is running")
+	}
+	stat := lastExecStat(c)
+	if stat == nil || stat.Runs != 1 || stat.Failures != 0 || stat.Error != "" {
+		t.Errorf("unexpected stat %#v", stat)
+	}
+}
+
+func TestNewInExecInvalid(t *testing.T) {
+	if _, err := hydra.NewInExec(&hydra.ConfigExec{Tag: "exec"}); err == nil {
+		t.Error("Command must be required")
+	}
+	if _, err := hydra.NewInExec(&hydra.ConfigExec{Tag: "exec", Command: "true", Format: hydra.FormatRegexp}); err == nil {
+		t.Error("Regexp must be required for regexp format")
+	}
+}
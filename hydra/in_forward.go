@@ -1,6 +1,7 @@
 package hydra
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
@@ -12,30 +13,65 @@ import (
 )
 
 const (
-	FlashInterval = 200 * time.Millisecond
+	FlashInterval    = 200 * time.Millisecond
+	HandshakeTimeout = 10 * time.Second
 )
 
 type InForward struct {
 	index        int
 	listener     net.Listener
 	Addr         net.Addr
+	udpConn      net.PacketConn
+	UDPAddr      net.Addr
 	messageCh    chan *fluent.FluentRecordSet
 	monitorCh    chan Stat
 	messageQueue *MessageQueue
+	auth         *fluent.AuthConfig
+	filters      Filters
 }
 
 func NewInForward(config *ConfigReceiver) (*InForward, error) {
 	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	tlsConfig, err := config.ServerTLSConfig(config.VerifyClientCert)
+	if err != nil {
+		log.Println("[error]", err)
+		return nil, err
+	}
+	filters, err := config.Filters("")
+	if err != nil {
+		log.Println("[error]", err)
+		return nil, err
+	}
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Println("[error]", err)
 		return nil, err
 	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+		log.Println("[info] Receiver TLS enabled")
+	}
 	log.Println("[info] Receiver listing", l.Addr())
 	f := &InForward{
 		listener:     l,
 		Addr:         l.Addr(),
 		messageQueue: NewMessageQueue(config.MaxBufferMessages),
+		auth:         config.AuthConfig(),
+		filters:      filters,
+	}
+	if f.auth != nil {
+		log.Println("[info] Receiver requires authentication by shared key")
+	}
+
+	// heartbeats from fluentd (heartbeat_type udp) arrive at the same port as TCP
+	_, port, _ := net.SplitHostPort(f.Addr.String())
+	udpConn, err := net.ListenPacket("udp", net.JoinHostPort(config.Host, port))
+	if err != nil {
+		log.Println("[warning] Couldn't listen UDP for heartbeats", err)
+	} else {
+		f.udpConn = udpConn
+		f.UDPAddr = udpConn.LocalAddr()
+		log.Println("[info] Receiver listing heartbeats", f.UDPAddr)
 	}
 	return f, nil
 }
@@ -54,9 +90,16 @@ func (f *InForward) Run(c *Context) {
 	}
 
 	go f.feed()
+	if f.udpConn != nil {
+		c.InputProcess.Add(1)
+		go f.respondHeartbeat(c)
+	}
 	go func() {
 		<-c.ControlCh
 		f.listener.Close()
+		if f.udpConn != nil {
+			f.udpConn.Close()
+		}
 	}()
 	for {
 		conn, err := f.listener.Accept()
@@ -77,6 +120,26 @@ func (f *InForward) Run(c *Context) {
 	}
 }
 
+// respondHeartbeat answers UDP heartbeats from fluentd's out_forward.
+func (f *InForward) respondHeartbeat(c *Context) {
+	defer c.InputProcess.Done()
+	buf := make([]byte, 1024)
+	for {
+		_, addr, err := f.udpConn.ReadFrom(buf)
+		if err != nil {
+			if strings.Index(err.Error(), "use of closed network connection") != -1 {
+				log.Println("[info] shutdown in_forward heartbeat")
+				return
+			}
+			log.Println("[error] heartbeat read error", err)
+			continue
+		}
+		if _, err := f.udpConn.WriteTo([]byte{0}, addr); err != nil {
+			log.Println("[warning] heartbeat response failed", err, addr)
+		}
+	}
+}
+
 func (f *InForward) feed() {
 	for {
 		if rs, ok := f.messageQueue.Dequeue(); ok {
@@ -99,6 +162,32 @@ func (f *InForward) handleConn(conn net.Conn, c *Context) {
 		}
 	}()
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake explicitly, not to be reported as a decode error
+		tlsConn.SetDeadline(time.Now().Add(HandshakeTimeout))
+		err := tlsConn.Handshake()
+		tlsConn.SetDeadline(time.Time{})
+		if err != nil {
+			log.Println("[warning] TLS handshake failed", err, conn.RemoteAddr())
+			f.monitorCh <- &ReceiverStat{
+				TLSHandshakeFailures: 1,
+			}
+			conn.Close()
+			return
+		}
+	}
+
+	if f.auth != nil {
+		if err := fluent.ServerHandshake(conn, f.auth, HandshakeTimeout); err != nil {
+			log.Println("[warning] Handshake failed", err, conn.RemoteAddr())
+			f.monitorCh <- &ReceiverStat{
+				Rejected: 1,
+			}
+			conn.Close()
+			return
+		}
+	}
+
 	for {
 		select {
 		case <-c.ControlCh:
@@ -106,7 +195,7 @@ func (f *InForward) handleConn(conn net.Conn, c *Context) {
 			return
 		default:
 		}
-		recordSets, err := fluent.DecodeEntries(conn)
+		recordSets, option, err := fluent.DecodeEntriesWithOption(conn)
 		if err == io.EOF {
 			conn.Close()
 			return
@@ -119,13 +208,29 @@ func (f *InForward) handleConn(conn net.Conn, c *Context) {
 		d := int64(0)
 		for _, recordSet := range recordSets {
 			rs := &recordSet
-			d += f.messageQueue.Enqueue(rs)
 			m += int64(len(rs.Records))
+			for _, rs := range f.filters.Apply(rs, f.monitorCh) {
+				d += f.messageQueue.Enqueue(rs)
+			}
 		}
-		f.monitorCh <- &ReceiverStat{
+		stat := &ReceiverStat{
 			Messages: m,
 			Disposed: d,
 			Buffered: int64(f.messageQueue.Len()),
 		}
+		if option != nil && option.Chunk != "" && d > 0 {
+			// buffered messages were disposed. the client resends the chunk when not acked
+			log.Println("[warning] Ack was not responded because", d, "messages were disposed", conn.RemoteAddr())
+			stat.Unacked = 1
+		} else if option != nil && option.Chunk != "" {
+			// record sets were enqueued. respond ack
+			if err := fluent.WriteAck(conn, option.Chunk); err != nil {
+				log.Println("[error] Write ack response failed", err, conn.RemoteAddr())
+				stat.Unacked = 1
+			} else {
+				stat.Acked = 1
+			}
+		}
+		f.monitorCh <- stat
 	}
 }
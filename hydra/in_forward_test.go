@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
 	"github.com/fujiwara/fluent-agent-hydra/hydra"
 	client "github.com/t-k/fluent-logger-golang/fluent"
 )
@@ -59,3 +60,143 @@ RECEIVE:
 		t.Errorf("arrived messages %d expected %d", n, 10)
 	}
 }
+
+func TestInForwardNoAckOnDisposed(t *testing.T) {
+	config := &hydra.ConfigReceiver{
+		Host:              "127.0.0.1",
+		Port:              0,
+		MaxBufferMessages: len(TestMessageLines),
+	}
+	c := hydra.NewContext()
+	inForward, err := hydra.NewInForward(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inForward)
+
+	logger, err := fluent.New(fluent.Config{Server: inForward.Addr.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	// MessageCh is not read. the 4th chunk disposes the 3rd chunk in the buffer
+	recordSet := prepareRecordSet()
+	for i := 0; i < 4; i++ {
+		chunk := fluent.NewChunkID()
+		packed, err := recordSet.PackAsPackedForwardWithOption(&fluent.ForwardOption{Chunk: chunk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := logger.Send(packed); err != nil {
+			t.Fatal(err)
+		}
+		err = logger.ReceiveAck(chunk, 1*time.Second)
+		if i < 3 && err != nil {
+			t.Errorf("chunk %d ack response failed %s", i, err)
+		} else if i == 3 && err == nil {
+			t.Errorf("chunk %d must not be acked", i)
+		}
+		// wait for feeding to MessageCh (FlashInterval)
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func TestInForwardAckResponse(t *testing.T) {
+	config := &hydra.ConfigReceiver{
+		Host:              "127.0.0.1",
+		Port:              0,
+		MaxBufferMessages: 1000,
+	}
+	c := hydra.NewContext()
+	inForward, err := hydra.NewInForward(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inForward)
+
+	logger, err := fluent.New(fluent.Config{Server: inForward.Addr.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	recordSet := prepareRecordSet()
+	for i := 0; i < 3; i++ {
+		chunk := fluent.NewChunkID()
+		packed, err := recordSet.PackAsPackedForwardWithOption(&fluent.ForwardOption{Chunk: chunk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := logger.Send(packed); err != nil {
+			t.Fatal(err)
+		}
+		if err := logger.ReceiveAck(chunk, 1*time.Second); err != nil {
+			t.Error("ack response failed", err)
+		}
+	}
+
+	n := 0
+	acked := int64(0)
+	timeout := time.After(2 * time.Second)
+RECEIVE:
+	for {
+		select {
+		case rs := <-c.MessageCh:
+			n += len(rs.Records)
+		case s := <-c.MonitorCh:
+			if rs, ok := s.(*hydra.ReceiverStat); ok {
+				acked += rs.Acked
+			}
+		case <-timeout:
+			break RECEIVE
+		}
+	}
+	if n != len(TestMessageLines)*3 {
+		t.Errorf("arrived messages %d expected %d", n, len(TestMessageLines)*3)
+	}
+	if acked != 3 {
+		t.Errorf("acked %d expected %d", acked, 3)
+	}
+}
+
+func TestInForwardHeartbeat(t *testing.T) {
+	config := &hydra.ConfigReceiver{
+		Host:              "127.0.0.1",
+		Port:              0,
+		MaxBufferMessages: 1000,
+	}
+	c := hydra.NewContext()
+	inForward, err := hydra.NewInForward(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inForward.UDPAddr == nil || inForward.UDPAddr.String() != inForward.Addr.String() {
+		t.Fatalf("UDP must listen on the same address as TCP %s %s", inForward.UDPAddr, inForward.Addr)
+	}
+	c.RunProcess(inForward)
+	c.StartProcess.Wait()
+
+	heartbeat := func() error {
+		conn, err := net.Dial("udp", inForward.UDPAddr.String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
+		if _, err := conn.Write([]byte{0}); err != nil {
+			return err
+		}
+		buf := make([]byte, 1)
+		_, err = conn.Read(buf)
+		return err
+	}
+	if err := heartbeat(); err != nil {
+		t.Error("heartbeat must be answered", err)
+	}
+
+	c.Shutdown()
+	if err := heartbeat(); err == nil {
+		t.Error("heartbeat must not be answered after shutdown")
+	}
+}
@@ -0,0 +1,211 @@
+package hydra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const (
+	DefaultHTTPPort        = 9880
+	DefaultHTTPMaxBodySize = 32 * 1024 * 1024
+)
+
+// InHTTP receives events by POST /<tag> (like fluentd's in_http).
+type InHTTP struct {
+	tagPrefix   string
+	maxBodySize int64
+	listener    net.Listener
+	Addr        net.Addr
+	messageCh   chan *fluent.FluentRecordSet
+	monitorCh   chan Stat
+	requests    sync.WaitGroup
+	closed      bool
+	mu          sync.Mutex
+}
+
+func NewInHTTP(config *ConfigHTTP) (*InHTTP, error) {
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Println("[error]", err)
+		return nil, err
+	}
+	log.Println("[info] HTTP input listening", l.Addr())
+	h := &InHTTP{
+		tagPrefix:   config.TagPrefix,
+		maxBodySize: config.MaxBodySize,
+		listener:    l,
+		Addr:        l.Addr(),
+	}
+	if h.maxBodySize == 0 {
+		h.maxBodySize = DefaultHTTPMaxBodySize
+	}
+	return h, nil
+}
+
+func (h *InHTTP) Run(c *Context) {
+	c.InputProcess.Add(1)
+	defer c.InputProcess.Done()
+	h.messageCh = c.MessageCh
+	h.monitorCh = c.MonitorCh
+
+	c.StartProcess.Done()
+
+	h.monitorCh <- &HTTPStat{
+		Address: h.Addr.String(),
+	}
+	server := &http.Server{Handler: h}
+	done := make(chan interface{})
+	go func() {
+		<-c.ControlCh
+		h.mu.Lock()
+		h.closed = true
+		h.mu.Unlock()
+		server.SetKeepAlivesEnabled(false)
+		h.listener.Close()
+		// wait for requests in progress
+		h.requests.Wait()
+		close(done)
+	}()
+	err := server.Serve(h.listener)
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	if !closed {
+		log.Println("[error]", err)
+		return
+	}
+	<-done
+	log.Println("[info] shutdown in_http")
+}
+
+func (h *InHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.begin() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.requests.Done()
+	code, err := h.handle(w, r)
+	if err != nil {
+		stat := &HTTPStat{Requests: 1, Errors: 1}
+		if code == http.StatusRequestEntityTooLarge {
+			stat.TooLarge = 1
+		}
+		h.monitorCh <- stat
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+// begin counts a request in progress. It returns false after shutdown was started.
+func (h *InHTTP) begin() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.requests.Add(1)
+	return true
+}
+
+func (h *InHTTP) handle(w http.ResponseWriter, r *http.Request) (int, error) {
+	if r.Method != "POST" {
+		return http.StatusMethodNotAllowed, errors.New("only POST is allowed")
+	}
+	tag := strings.Replace(strings.Trim(r.URL.Path, "/"), "/", ".", -1)
+	if tag == "" {
+		return http.StatusBadRequest, errors.New("tag is required as path")
+	}
+	if h.tagPrefix != "" {
+		tag = h.tagPrefix + "." + tag
+	}
+
+	ts := time.Now()
+	if t := r.URL.Query().Get("time"); t != "" {
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid time %s", t)
+		}
+		sec, frac := math.Modf(f)
+		ts = time.Unix(int64(sec), int64(frac*1e9))
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		if strings.Contains(err.Error(), "too large") {
+			return http.StatusRequestEntityTooLarge, err
+		}
+		return http.StatusBadRequest, err
+	}
+
+	var records []map[string]interface{}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/msgpack", "application/x-msgpack":
+		records, err = fluent.DecodeMsgpackRecords(body)
+	default:
+		records, err = decodeJSONRecords(body)
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if len(records) > 0 {
+		recordSet := &fluent.FluentRecordSet{
+			Tag:     tag,
+			Records: make([]fluent.FluentRecordType, len(records)),
+		}
+		for i, data := range records {
+			recordSet.Records[i] = &fluent.TinyFluentRecord{
+				Timestamp: ts,
+				Data:      data,
+			}
+		}
+		h.messageCh <- recordSet
+	}
+	h.monitorCh <- &HTTPStat{
+		Requests: 1,
+		Records:  int64(len(records)),
+	}
+	return http.StatusOK, nil
+}
+
+// decodeJSONRecords decodes a JSON object or an array of objects into records.
+func decodeJSONRecords(b []byte) ([]map[string]interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		values = []interface{}{v}
+	case []interface{}:
+		values = v
+	default:
+		return nil, errors.New("JSON must be an object or an array of objects")
+	}
+	records := make([]map[string]interface{}, len(values))
+	for i, value := range values {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("JSON must be an object or an array of objects")
+		}
+		records[i] = data
+	}
+	return records, nil
+}
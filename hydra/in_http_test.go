@@ -0,0 +1,115 @@
+package hydra_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+	"github.com/ugorji/go/codec"
+)
+
+func runInHTTP(t *testing.T, config *hydra.ConfigHTTP) (*hydra.Context, *hydra.InHTTP) {
+	c := hydra.NewContext()
+	inHTTP, err := hydra.NewInHTTP(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inHTTP)
+	return c, inHTTP
+}
+
+func TestInHTTP(t *testing.T) {
+	c, inHTTP := runInHTTP(t, &hydra.ConfigHTTP{Host: "127.0.0.1", TagPrefix: "http"})
+	url := "http://" + inHTTP.Addr.String()
+
+	// a JSON object with time
+	res, err := http.Post(url+"/app/access?time=1451606400.5", "application/json", strings.NewReader(`{"path":"/","status":200}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d", res.StatusCode)
+	}
+	rs := <-c.MessageCh
+	if rs.Tag != "http.app.access" || len(rs.Records) != 1 {
+		t.Errorf("unexpected record set %#v", rs)
+	}
+	record := rs.Records[0].(*fluent.TinyFluentRecord)
+	if !record.Timestamp.Equal(time.Unix(1451606400, 500000000)) {
+		t.Errorf("unexpected timestamp %s", record.Timestamp)
+	}
+	if record.Data["path"] != "/" || record.Data["status"] != float64(200) {
+		t.Errorf("unexpected record %#v", record.Data)
+	}
+
+	// a JSON array
+	res, _ = http.Post(url+"/app", "application/json", strings.NewReader(`[{"n":1},{"n":2},{"n":3}]`))
+	res.Body.Close()
+	if rs := <-c.MessageCh; rs.Tag != "http.app" || len(rs.Records) != 3 {
+		t.Errorf("unexpected record set %#v", rs)
+	}
+
+	// msgpack
+	var buf bytes.Buffer
+	codec.NewEncoder(&buf, &codec.MsgpackHandle{}).Encode([]map[string]interface{}{{"key": "value"}})
+	res, _ = http.Post(url+"/app", "application/msgpack", &buf)
+	res.Body.Close()
+	rs = <-c.MessageCh
+	if v, _ := rs.Records[0].GetData("key"); len(rs.Records) != 1 || v != "value" {
+		t.Errorf("unexpected record set %#v", rs)
+	}
+
+	c.Shutdown()
+}
+
+func TestInHTTPErrors(t *testing.T) {
+	c, inHTTP := runInHTTP(t, &hydra.ConfigHTTP{Host: "127.0.0.1", MaxBodySize: 16})
+	url := "http://" + inHTTP.Addr.String()
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"GET", "/app", "", http.StatusMethodNotAllowed},
+		{"POST", "/", `{}`, http.StatusBadRequest},
+		{"POST", "/app", `{"broken`, http.StatusBadRequest},
+		{"POST", "/app", `"string"`, http.StatusBadRequest},
+		{"POST", "/app?time=abc", `{}`, http.StatusBadRequest},
+		{"POST", "/app", `{"too":"large body"}`, http.StatusRequestEntityTooLarge},
+	} {
+		req, _ := http.NewRequest(tc.method, url+tc.path, strings.NewReader(tc.body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.code {
+			t.Errorf("%s %s %s: unexpected status %d expected %d", tc.method, tc.path, tc.body, res.StatusCode, tc.code)
+		}
+	}
+
+	stats := &hydra.Stats{}
+	timeout := time.After(500 * time.Millisecond)
+RECEIVE:
+	for {
+		select {
+		case s := <-c.MonitorCh:
+			if s, ok := s.(*hydra.HTTPStat); ok {
+				s.ApplyTo(stats)
+			}
+		case <-timeout:
+			break RECEIVE
+		}
+	}
+	if s := stats.HTTP; s.Requests != 6 || s.Errors != 6 || s.TooLarge != 1 || s.Records != 0 {
+		t.Errorf("unexpected stats %#v", s)
+	}
+	c.Shutdown()
+}
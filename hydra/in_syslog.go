@@ -0,0 +1,229 @@
+package hydra
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const (
+	DefaultSyslogTag     = "syslog"
+	DefaultSyslogPort    = 5140
+	syslogMaxMessageSize = 64 * 1024
+)
+
+// InSyslog receives syslog messages, and tags records as Tag.facility.severity.
+type InSyslog struct {
+	tag        string
+	fieldName  string
+	format     string
+	path       string
+	listener   net.Listener
+	packetConn net.PacketConn
+	Addr       net.Addr
+	messageCh  chan *fluent.FluentRecordSet
+	monitorCh  chan Stat
+}
+
+func NewInSyslog(config *ConfigSyslog) (*InSyslog, error) {
+	switch config.Format {
+	case "", SyslogRFC3164, SyslogRFC5424:
+	default:
+		return nil, fmt.Errorf("Unsupported syslog Format %s", config.Format)
+	}
+	s := &InSyslog{
+		tag:       config.Tag,
+		fieldName: config.FieldName,
+		format:    config.Format,
+	}
+	var addr string
+	switch config.Protocol {
+	case "udp", "tcp":
+		addr = fmt.Sprintf("%s:%d", config.Host, config.Port)
+	case "unix", "unixgram":
+		if config.Path == "" {
+			return nil, fmt.Errorf("Path is required for syslog Protocol %s", config.Protocol)
+		}
+		// remove a socket file which was left by the previous process
+		if st, err := os.Stat(config.Path); err == nil && st.Mode()&os.ModeSocket != 0 {
+			os.Remove(config.Path)
+		}
+		addr = config.Path
+		s.path = config.Path
+	default:
+		return nil, fmt.Errorf("Unsupported syslog Protocol %s", config.Protocol)
+	}
+
+	var err error
+	switch config.Protocol {
+	case "udp", "unixgram":
+		s.packetConn, err = net.ListenPacket(config.Protocol, addr)
+		if err == nil {
+			s.Addr = s.packetConn.LocalAddr()
+		}
+	default:
+		s.listener, err = net.Listen(config.Protocol, addr)
+		if err == nil {
+			s.Addr = s.listener.Addr()
+		}
+	}
+	if err != nil {
+		log.Println("[error]", err)
+		return nil, err
+	}
+	log.Println("[info] Syslog listening", config.Protocol, s.Addr, "tag:", s.tag)
+	return s, nil
+}
+
+func (s *InSyslog) Run(c *Context) {
+	c.InputProcess.Add(1)
+	defer c.InputProcess.Done()
+	s.messageCh = c.MessageCh
+	s.monitorCh = c.MonitorCh
+
+	c.StartProcess.Done()
+
+	go func() {
+		<-c.ControlCh
+		if s.packetConn != nil {
+			s.packetConn.Close()
+		} else {
+			s.listener.Close()
+		}
+	}()
+	if s.packetConn != nil {
+		s.servePacket()
+		if s.path != "" {
+			os.Remove(s.path)
+		}
+	} else {
+		s.serveStream(c)
+	}
+}
+
+// servePacket receives a message per datagram.
+func (s *InSyslog) servePacket() {
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, _, err := s.packetConn.ReadFrom(buf)
+		if err != nil {
+			if strings.Index(err.Error(), "use of closed network connection") != -1 {
+				log.Println("[info] shutdown in_syslog", s.Addr)
+				return
+			}
+			log.Println("[error] syslog read error", err)
+			continue
+		}
+		s.emit(buf[0:n])
+	}
+}
+
+func (s *InSyslog) serveStream(c *Context) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if strings.Index(err.Error(), "use of closed network connection") != -1 {
+				log.Println("[info] shutdown in_syslog", s.Addr)
+				return
+			}
+			log.Println("[error] accept error", err)
+			continue
+		}
+		// added before the listener is closed by shutdown
+		c.InputProcess.Add(1)
+		go s.handleConn(conn, c)
+	}
+}
+
+func (s *InSyslog) handleConn(conn net.Conn, c *Context) {
+	defer c.InputProcess.Done()
+	done := make(chan interface{})
+	defer close(done)
+	go func() {
+		select {
+		case <-c.ControlCh:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+	for {
+		frame, err := readSyslogFrame(r)
+		if len(frame) > 0 {
+			s.emit(frame)
+		}
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			select {
+			case <-c.ControlCh:
+			default:
+				log.Println("[error] syslog read error", err, conn.RemoteAddr())
+			}
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads an octet-counted (RFC6587 "MSG-LEN SP SYSLOG-MSG") or a newline-framed message.
+// Both frames are limited to syslogMaxMessageSize, and an error is returned for longer ones.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if head[0] < '0' || head[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("too long syslog message over %d bytes", syslogMaxMessageSize)
+		}
+		// the slice is overwritten by the next read
+		return append([]byte(nil), line...), err
+	}
+	b, err := r.ReadSlice(' ')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("syslog message length not found")
+	} else if err != nil {
+		return nil, err
+	}
+	l := string(b)
+	n, err := strconv.Atoi(strings.TrimSuffix(l, " "))
+	if err != nil || n > syslogMaxMessageSize {
+		return nil, fmt.Errorf("invalid syslog message length %s", l)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (s *InSyslog) emit(line []byte) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	m, err := ParseSyslog(line, s.format, time.Now())
+	if err != nil {
+		log.Printf("[warning] %s: %q", err, line)
+		return
+	}
+	s.messageCh <- &fluent.FluentRecordSet{
+		Tag: s.tag + "." + m.Facility() + "." + m.Severity(),
+		Records: []fluent.FluentRecordType{
+			&fluent.TinyFluentRecord{
+				Timestamp: m.Timestamp,
+				Data:      m.Record(s.fieldName),
+			},
+		},
+	}
+}
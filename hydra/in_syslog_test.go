@@ -0,0 +1,143 @@
+package hydra_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func runInSyslog(t *testing.T, config *hydra.ConfigSyslog) (*hydra.Context, *hydra.InSyslog) {
+	config.FieldName = "message"
+	c := hydra.NewContext()
+	inSyslog, err := hydra.NewInSyslog(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inSyslog)
+	c.StartProcess.Wait()
+	return c, inSyslog
+}
+
+func receiveRecordSets(ch chan *fluent.FluentRecordSet, n int) []*fluent.FluentRecordSet {
+	recordSets := make([]*fluent.FluentRecordSet, 0, n)
+	for len(recordSets) < n {
+		select {
+		case rs := <-ch:
+			recordSets = append(recordSets, rs)
+		case <-time.After(time.Second):
+			return recordSets
+		}
+	}
+	return recordSets
+}
+
+func TestInSyslogUDP(t *testing.T) {
+	c, inSyslog := runInSyslog(t, &hydra.ConfigSyslog{Tag: "syslog", Protocol: "udp", Host: "127.0.0.1", Port: 0})
+	conn, err := net.Dial("udp", inSyslog.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("<34>Oct 11 22:14:15 mymachine su: 'su root' failed\n"))
+	conn.Write([]byte("invalid message"))
+	conn.Write([]byte("<165>1 2003-10-11T22:14:15.003Z mymachine evntslog - ID47 - event"))
+	conn.Close()
+
+	recordSets := receiveRecordSets(c.MessageCh, 2)
+	if len(recordSets) != 2 {
+		t.Fatalf("unexpected record sets %#v", recordSets)
+	}
+	if rs := recordSets[0]; rs.Tag != "syslog.auth.crit" {
+		t.Errorf("unexpected tag %s", rs.Tag)
+	} else if v, _ := rs.Records[0].GetData("message"); v != "'su root' failed" {
+		t.Errorf("unexpected message %v", v)
+	}
+	if rs := recordSets[1]; rs.Tag != "syslog.local4.notice" {
+		t.Errorf("unexpected tag %s", rs.Tag)
+	} else if v, _ := rs.Records[0].GetData("msgid"); v != "ID47" {
+		t.Errorf("unexpected msgid %v", v)
+	}
+	c.Shutdown()
+}
+
+func TestInSyslogTCP(t *testing.T) {
+	c, inSyslog := runInSyslog(t, &hydra.ConfigSyslog{Tag: "sys", Protocol: "tcp", Host: "127.0.0.1", Port: 0})
+	conn, err := net.Dial("tcp", inSyslog.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newline-framed and octet-counted messages in a stream
+	conn.Write([]byte("<13>Oct 11 22:14:15 host app: first\n"))
+	conn.Write([]byte("36 <13>Oct 11 22:14:15 host app: second"))
+	conn.Write([]byte("<13>Oct 11 22:14:15 host app: third\n"))
+
+	recordSets := receiveRecordSets(c.MessageCh, 3)
+	if len(recordSets) != 3 {
+		t.Fatalf("unexpected record sets %#v", recordSets)
+	}
+	for i, expected := range []string{"first", "second", "third"} {
+		rs := recordSets[i]
+		if v, _ := rs.Records[0].GetData("message"); rs.Tag != "sys.user.notice" || v != expected {
+			t.Errorf("unexpected record set %s %v", rs.Tag, v)
+		}
+	}
+	// connections are closed by shutdown
+	c.Shutdown()
+	conn.Close()
+}
+
+func TestInSyslogTCPTooLong(t *testing.T) {
+	c, inSyslog := runInSyslog(t, &hydra.ConfigSyslog{Tag: "sys", Protocol: "tcp", Host: "127.0.0.1", Port: 0})
+	defer c.Shutdown()
+	conn, err := net.Dial("tcp", inSyslog.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a newline-framed message never terminated
+	conn.Write([]byte("<13>Oct 11 22:14:15 host app: "))
+	conn.Write(bytes.Repeat([]byte("x"), 128*1024))
+
+	// the connection is closed by the server
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	// (reset by peer when unread data remains)
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Errorf("connection must be closed %v", err)
+	}
+	if recordSets := receiveRecordSets(c.MessageCh, 1); len(recordSets) != 0 {
+		t.Errorf("unexpected record sets %#v", recordSets)
+	}
+}
+
+func TestInSyslogUnix(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "syslog.sock")
+
+	c, _ := runInSyslog(t, &hydra.ConfigSyslog{Tag: "syslog", Protocol: "unixgram", Path: path})
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("<30>Oct 11 22:14:15 host app[42]: via unix socket"))
+	conn.Close()
+
+	recordSets := receiveRecordSets(c.MessageCh, 1)
+	if len(recordSets) != 1 {
+		t.Fatalf("unexpected record sets %#v", recordSets)
+	}
+	if v, _ := recordSets[0].Records[0].GetData("procid"); recordSets[0].Tag != "syslog.daemon.info" || v != "42" {
+		t.Errorf("unexpected record set %s %v", recordSets[0].Tag, v)
+	}
+	c.Shutdown()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("socket file must be removed after shutdown", err)
+	}
+}
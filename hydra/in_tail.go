@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/fujiwara/fluent-agent-hydra/fluent"
@@ -28,13 +30,37 @@ type InTail struct {
 	format         FileFormat
 	recordModifier *RecordModifier
 	regexp         *Regexp
+	csv            *CSVParser
+	filters        Filters
 	position       int64
+	positionFile   *PositionFile
+	readFromHead   bool
+	multiline      *Multiline
+	rotateWait     time.Duration
+	inodes         *inodeSet
+	stopCh         chan interface{}
+	stopOnce       sync.Once
+	running        sync.WaitGroup
 }
 
 type Watcher struct {
-	watcher      *fsnotify.Watcher
-	watchingDir  map[string]bool
-	watchingFile map[string]chan fsnotify.Event
+	watcher         *fsnotify.Watcher
+	watchingDir     map[string]bool
+	watchingFile    map[string]*watchTarget
+	watchingPattern map[string]*watchTarget
+	mu              sync.Mutex
+}
+
+type watchTarget struct {
+	ch   chan fsnotify.Event
+	done chan interface{}
+}
+
+func newWatchTarget() *watchTarget {
+	return &watchTarget{
+		ch:   make(chan fsnotify.Event),
+		done: make(chan interface{}),
+	}
 }
 
 func NewWatcher() (*Watcher, error) {
@@ -44,9 +70,10 @@ func NewWatcher() (*Watcher, error) {
 		return nil, err
 	}
 	w := &Watcher{
-		watcher:      watcher,
-		watchingDir:  make(map[string]bool),
-		watchingFile: make(map[string]chan fsnotify.Event),
+		watcher:         watcher,
+		watchingDir:     make(map[string]bool),
+		watchingFile:    make(map[string]*watchTarget),
+		watchingPattern: make(map[string]*watchTarget),
 	}
 	return w, nil
 }
@@ -56,7 +83,10 @@ func (w *Watcher) Run(c *Context) {
 	defer c.InputProcess.Done()
 	c.StartProcess.Done()
 
-	if len(w.watchingFile) == 0 {
+	w.mu.Lock()
+	n := len(w.watchingFile) + len(w.watchingPattern)
+	w.mu.Unlock()
+	if n == 0 {
 		// no need to watch
 		return
 	}
@@ -66,8 +96,14 @@ func (w *Watcher) Run(c *Context) {
 			log.Println("[info] shutdown file watcher")
 			return
 		case ev := <-w.watcher.Events:
-			if eventCh, ok := w.watchingFile[ev.Name]; ok {
-				eventCh <- ev
+			for _, target := range w.targets(ev.Name) {
+				select {
+				case target.ch <- ev:
+				case <-target.done:
+				case <-c.ControlCh:
+					log.Println("[info] shutdown file watcher")
+					return
+				}
 			}
 		case err := <-w.watcher.Errors:
 			log.Println("[warning] watcher error", err)
@@ -75,24 +111,89 @@ func (w *Watcher) Run(c *Context) {
 	}
 }
 
+// targets returns watchTargets which are interested in the file.
+func (w *Watcher) targets(filename string) []*watchTarget {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	targets := make([]*watchTarget, 0, 1)
+	if target, ok := w.watchingFile[filename]; ok {
+		targets = append(targets, target)
+	}
+	for pattern, target := range w.watchingPattern {
+		if matched, _ := filepath.Match(pattern, filename); matched {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func (w *Watcher) watchDir(dir string) error {
+	if _, ok := w.watchingDir[dir]; ok { // already watching
+		return nil
+	}
+	log.Println("[info] watching events of directory", dir)
+	err := w.watcher.Add(dir)
+	if err != nil {
+		log.Println("[error] Couldn't watch event of", dir, err)
+		return err
+	}
+	w.watchingDir[dir] = true
+	return nil
+}
+
 func (w *Watcher) WatchFile(filename string) (chan fsnotify.Event, error) {
-	parent := filepath.Dir(filename)
-	log.Println("[info] watching events of directory", parent)
-	if _, ok := w.watchingDir[parent]; ok { // already watching
-		ch := make(chan fsnotify.Event)
-		w.watchingFile[filename] = ch
-		return ch, nil
-	} else {
-		err := w.watcher.Add(parent)
-		if err != nil {
-			log.Println("[error] Couldn't watch event of", parent, err)
-			return nil, err
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.watchDir(filepath.Dir(filename)); err != nil {
+		return nil, err
+	}
+	target := newWatchTarget()
+	w.watchingFile[filename] = target
+	return target.ch, nil
+}
+
+// UnwatchFile stops delivering events of the file.
+func (w *Watcher) UnwatchFile(filename string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if target, ok := w.watchingFile[filename]; ok {
+		close(target.done)
+		delete(w.watchingFile, filename)
+	}
+}
+
+// WatchPattern delivers events of files which match the glob pattern.
+func (w *Watcher) WatchPattern(pattern string) (chan fsnotify.Event, error) {
+	if err := w.WatchPatternDirs(pattern); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if target, ok := w.watchingPattern[pattern]; ok {
+		return target.ch, nil
+	}
+	target := newWatchTarget()
+	w.watchingPattern[pattern] = target
+	return target.ch, nil
+}
+
+// WatchPatternDirs watches directories which may contain files matching the pattern.
+func (w *Watcher) WatchPatternDirs(pattern string) error {
+	dirs, err := filepath.Glob(filepath.Dir(pattern))
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, dir := range dirs {
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			continue
+		}
+		if err := w.watchDir(dir); err != nil {
+			return err
 		}
-		w.watchingDir[parent] = true
-		ch := make(chan fsnotify.Event)
-		w.watchingFile[filename] = ch
-		return ch, nil
 	}
+	return nil
 }
 
 func Rel2Abs(filename string) (string, error) {
@@ -107,20 +208,39 @@ func Rel2Abs(filename string) (string, error) {
 	return filepath.Join(cwd, filename), nil
 }
 
+// checkRegexps returns an error when Regexp or MultilineStart (of [[Logs]] or [[Exec]]) was not compiled (e.g. unknown grok patterns).
+func checkRegexps(format FileFormat, regexp *Regexp, multilineStart *Regexp) error {
+	if format == FormatRegexp && (regexp == nil || regexp.Regexp == nil) {
+		return errors.New("Regexp is required for Format regexp")
+	}
+	if multilineStart != nil && multilineStart.Regexp == nil {
+		return errors.New("MultilineStart is not compiled")
+	}
+	return nil
+}
+
 func NewInTail(config *ConfigLogfile, watcher *Watcher) (*InTail, error) {
-	modifier := &RecordModifier{
-		convertMap:    config.ConvertMap,
-		timeParse:     config.TimeParse,
-		timeKey:       config.TimeKey,
-		timeConverter: TimeConverter(config.TimeFormat),
+	if err := checkRegexps(config.Format, config.Regexp, config.MultilineStart); err != nil {
+		return nil, err
 	}
+	modifier := NewRecordModifier(config.ConvertMap, config.TimeParse, config.TimeKey, config.TimeFormat)
 	if config.IsStdin() {
+		csv, err := NewCSVParser(config.Format, config.Keys, config.Delimiter)
+		if err != nil {
+			return nil, err
+		}
+		filters, err := config.Filters(StdinFilename)
+		if err != nil {
+			return nil, err
+		}
 		return &InTail{
 			filename:       StdinFilename,
 			tag:            config.Tag,
 			fieldName:      config.FieldName,
 			format:         config.Format,
 			recordModifier: modifier,
+			csv:            csv,
+			filters:        filters,
 		}, nil
 	}
 
@@ -128,19 +248,44 @@ func NewInTail(config *ConfigLogfile, watcher *Watcher) (*InTail, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newInTailFile(config, filename, config.Tag, modifier, watcher)
+}
+
+func newInTailFile(config *ConfigLogfile, filename string, tag string, modifier *RecordModifier, watcher *Watcher) (*InTail, error) {
+	csv, err := NewCSVParser(config.Format, config.Keys, config.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := config.Filters(filename)
+	if err != nil {
+		return nil, err
+	}
+	var positionFile *PositionFile
+	if config.PosFile != "" {
+		positionFile, err = OpenPositionFile(config.PosFile)
+		if err != nil {
+			return nil, err
+		}
+	}
 	eventCh, err := watcher.WatchFile(filename)
 	if err != nil {
 		return nil, err
 	}
 	return &InTail{
 		filename:       filename,
-		tag:            config.Tag,
+		tag:            tag,
 		fieldName:      config.FieldName,
 		lastReadAt:     time.Now(),
 		eventCh:        eventCh,
+		stopCh:         make(chan interface{}),
 		format:         config.Format,
 		recordModifier: modifier,
 		regexp:         config.Regexp,
+		csv:            csv,
+		filters:        filters,
+		positionFile:   positionFile,
+		multiline:      NewMultiline(config),
+		rotateWait:     config.RotateWait.Duration,
 	}, nil
 }
 
@@ -154,6 +299,15 @@ func (t *InTail) Run(c *Context) {
 
 	c.StartProcess.Done()
 
+	t.follow(c)
+}
+
+func (t *InTail) follow(c *Context) {
+	defer func() {
+		if err := t.positionFile.Flush(); err != nil {
+			log.Println("[warning] couldn't save position file", err)
+		}
+	}()
 	if t.eventCh == nil {
 		err := t.TailStdin(c)
 		if err != nil {
@@ -166,8 +320,9 @@ func (t *InTail) Run(c *Context) {
 		}
 	}
 
+	t.resumeRotated(c)
 	log.Println("[info] Trying trail file", t.filename)
-	f, err := t.newTrailFile(SEEK_TAIL, c)
+	f, err := t.newTrailFile(t.startPosition(), c)
 	if err != nil {
 		if _, ok := err.(Signal); ok {
 			log.Println("[info]", err)
@@ -182,6 +337,12 @@ func (t *InTail) Run(c *Context) {
 			if err != nil {
 				if _, ok := err.(Signal); ok {
 					log.Println("[info]", err)
+					if t.stopped() {
+						// the file was vanished. read the rest of it
+						t.release(f, c)
+					} else {
+						f.Close()
+					}
 					return
 				} else {
 					log.Println("[warning]", err)
@@ -203,17 +364,65 @@ func (t *InTail) Run(c *Context) {
 	}
 }
 
+// startPosition returns a position recorded in the position file when the inode of the file is not changed.
+func (t *InTail) startPosition() int64 {
+	if t.positionFile == nil {
+		return t.noPosition()
+	}
+	pos, ok := t.positionFile.Get(t.filename)
+	if !ok {
+		return t.noPosition()
+	}
+	stat, err := os.Stat(t.filename)
+	if err != nil {
+		return SEEK_HEAD
+	}
+	if inode := inodeOf(stat); inode != pos.Inode {
+		log.Printf("[info] %s inode was changed (%d => %d). Read from head", t.filename, pos.Inode, inode)
+		return SEEK_HEAD
+	}
+	if size := stat.Size(); pos.Offset > size {
+		log.Printf("[warning] %s was truncated (recorded position %d > size %d). Read from head", t.filename, pos.Offset, size)
+		return SEEK_HEAD
+	}
+	log.Println("[info]", t.filename, "resume from recorded position", pos.Offset)
+	return pos.Offset
+}
+
+func (t *InTail) noPosition() int64 {
+	if t.readFromHead {
+		return SEEK_HEAD
+	}
+	return SEEK_TAIL
+}
+
+func (t *InTail) stopped() bool {
+	select {
+	case <-t.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops tailing the file.
+func (t *InTail) Stop() {
+	if t.stopCh == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		close(t.stopCh)
+	})
+}
+
 func (t *InTail) newTrailFile(startPos int64, c *Context) (*File, error) {
 	seekTo := startPos
 	first := true
 	for {
 		f, err := openFile(t.filename, seekTo)
 		if err == nil {
-			f.Tag = t.tag
-			f.FieldName = t.fieldName
-			f.Format = t.format
-			f.RecordModifier = t.recordModifier
-			f.Regexp = t.regexp
+			t.setupFile(f)
+			f.SavePosition()
 			log.Println("[info] Trailing file:", f.Path, "tag:", f.Tag, "format:", t.format)
 			t.monitorCh <- f.UpdateStat()
 			return f, nil
@@ -232,23 +441,93 @@ func (t *InTail) newTrailFile(startPos int64, c *Context) (*File, error) {
 		select {
 		case <-c.ControlCh:
 			return nil, Signal{"shutdown in_tail: " + t.filename}
+		case <-t.stopCh:
+			return nil, Signal{"stop in_tail: " + t.filename}
 		case <-time.NewTimer(OpenRetryInterval).C:
 		}
 	}
 }
 
+func (t *InTail) setupFile(f *File) {
+	f.Tag = t.tag
+	f.FieldName = t.fieldName
+	f.Format = t.format
+	f.RecordModifier = t.recordModifier
+	f.Regexp = t.regexp
+	f.PositionFile = t.positionFile
+	f.Multiline = t.multiline.New()
+	f.CSV = t.csv.New()
+	f.Filters = t.filters
+	t.inodes.add(f.inode)
+	if f.CSV.NeedsHeader() && f.Position > 0 {
+		if err := f.CSV.ReadHeader(f.Path); err != nil {
+			log.Println("[warning]", f.Path, "couldn't read CSV header", err)
+		}
+	}
+}
+
+// resumeRotated keeps reading the rotated file recorded in the position file
+// (the agent was stopped in RotateWait).
+func (t *InTail) resumeRotated(c *Context) {
+	if t.positionFile == nil {
+		return
+	}
+	key := t.filename + RotatedFileSuffix
+	pos, ok := t.positionFile.Get(key)
+	if !ok {
+		return
+	}
+	path := t.findInode(pos.Inode)
+	if t.rotateWait <= 0 || path == "" {
+		t.positionFile.Remove(key)
+		return
+	}
+	f, err := openFile(path, pos.Offset)
+	if err != nil {
+		log.Println("[warning]", err)
+		t.positionFile.Remove(key)
+		return
+	}
+	t.setupFile(f)
+	// the rotated file is tracked as the rotated one of t.filename
+	f.Path = t.filename
+	log.Println("[info]", path, "resume from recorded position", pos.Offset, "as", key)
+	c.InputProcess.Add(1)
+	t.running.Add(1)
+	go t.drainRotated(f, c)
+}
+
+// findInode returns a path of the file which has the inode in the directory of t.filename.
+func (t *InTail) findInode(inode uint64) string {
+	if inode == 0 {
+		return ""
+	}
+	dir := filepath.Dir(t.filename)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, stat := range files {
+		if stat.Mode().IsRegular() && inodeOf(stat) == inode {
+			return filepath.Join(dir, stat.Name())
+		}
+	}
+	return ""
+}
+
 func (t *InTail) watchFileEvent(f *File, c *Context) error {
 	select {
 	case <-c.ControlCh:
 		return Signal{"shutdown in_tail: " + f.Path}
+	case <-t.stopCh:
+		return Signal{"stop in_tail: " + f.Path}
 	case ev := <-t.eventCh:
 		if ev.Op&fsnotify.Write == fsnotify.Write {
 			break
 		}
 		if ev.Op&fsnotify.Remove == fsnotify.Remove || ev.Op&fsnotify.Rename == fsnotify.Rename {
 			log.Println("[info] fsevent", ev.String())
-			f.tailAndSend(t.messageCh, t.monitorCh)
-			f.Close()
+			t.release(f, c)
 			return errors.New(t.filename + " was closed")
 		} else if ev.Op&fsnotify.Create == fsnotify.Create {
 			return nil
@@ -273,6 +552,65 @@ func (t *InTail) watchFileEvent(f *File, c *Context) error {
 	return nil
 }
 
+// release reads the rest of the file which was rotated or removed, and closes it.
+func (t *InTail) release(f *File, c *Context) {
+	f.tailAndSend(t.messageCh, t.monitorCh)
+	if t.rotateWait > 0 {
+		// keep reading the rotated file in parallel with the new file
+		c.InputProcess.Add(1)
+		t.running.Add(1)
+		go t.drainRotated(f, c)
+	} else {
+		f.FlushMultiline(t.messageCh, t.monitorCh)
+		f.Close()
+	}
+}
+
+// drainRotated reads the rotated file until RotateWait elapsed (also after Stop).
+func (t *InTail) drainRotated(f *File, c *Context) {
+	defer c.InputProcess.Done()
+	defer t.running.Done()
+	defer f.Close()
+
+	// the position file records the new file and the rotated file apart
+	f.Rotated = true
+	f.FileStat = &FileStat{}
+	f.SavePosition()
+	log.Println("[info]", f.Path, "was rotated. Keep reading for", t.rotateWait)
+
+	deadline := time.NewTimer(t.rotateWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(TailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ControlCh:
+			// resume reading the rest after restart
+			if err := f.PositionFile.Flush(); err != nil {
+				log.Println("[warning] couldn't save position file", err)
+			}
+			return
+		case <-ticker.C:
+			if err := f.tailAndSend(t.messageCh, t.monitorCh); err != io.EOF {
+				log.Println("[warning]", f.Path, "(rotated)", err)
+				return
+			}
+		case <-deadline.C:
+			f.tailAndSend(t.messageCh, t.monitorCh)
+			f.FlushMultiline(t.messageCh, t.monitorCh)
+			if err := f.PositionFile.Remove(f.positionKey()); err != nil {
+				log.Println("[warning] couldn't save position file", err)
+			}
+			log.Println("[info]", f.Path, "(rotated) was closed")
+			t.monitorCh <- &FileStat{
+				File:    f.UpdateStat().File,
+				Removed: true,
+			}
+			return
+		}
+	}
+}
+
 func (t *InTail) TailStdin(c *Context) error {
 	t.monitorCh <- &FileStat{
 		Tag:      t.tag,
@@ -287,7 +625,10 @@ func (t *InTail) TailStdin(c *Context) error {
 	for scanner.Scan() {
 		b := scanner.Bytes()
 		t.position += int64(len(b) + 1)
-		t.messageCh <- NewFluentRecordSet(t.tag, t.fieldName, t.format, t.recordModifier, t.regexp, b)
+		rs := newFluentRecordSet(t.tag, t.fieldName, t.format, t.recordModifier, t.regexp, t.csv, [][]byte{b})
+		for _, rs := range t.filters.Apply(rs, t.monitorCh) {
+			t.messageCh <- rs
+		}
 		t.monitorCh <- &FileStat{
 			File:     StdinFilename,
 			Position: t.position,
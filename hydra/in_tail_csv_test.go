@@ -0,0 +1,60 @@
+package hydra_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestTrailCSVHeader(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	file, _ := ioutil.TempFile(tmpdir, "logfile.")
+	defer os.RemoveAll(tmpdir)
+	// the header was written before tailing from the end of file
+	file.WriteString("id,name\n0,skipped\n")
+
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:        "test",
+		File:       file.Name(),
+		Format:     hydra.FormatCSV,
+		ConvertMap: hydra.NewConvertMap("id:integer"),
+		FieldName:  "message",
+	}
+	c := hydra.NewContext()
+	watcher, err := hydra.NewWatcher()
+	if err != nil {
+		t.Error(err)
+	}
+	inTail, err := hydra.NewInTail(configLogfile, watcher)
+	if err != nil {
+		t.Error(err)
+	}
+	c.RunProcess(inTail)
+	c.RunProcess(watcher)
+	go fileWriter(t, file, []string{"1,foo\n", "2,\"bar, baz\"\n"})
+
+	expected := []map[string]interface{}{
+		{"id": int64(1), "name": "foo"},
+		{"id": int64(2), "name": "bar, baz"},
+	}
+	i := 0
+	timeout := time.After(5 * time.Second)
+	for i < len(expected) {
+		select {
+		case recordSet := <-c.MessageCh:
+			for _, record := range recordSet.Records {
+				for k, v := range expected[i] {
+					if d, _ := record.GetData(k); d != v {
+						t.Errorf("records[%d] %s expected %#v got %#v", i, k, v, d)
+					}
+				}
+				i++
+			}
+		case <-timeout:
+			t.Fatalf("timed out. received %d records", i)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package hydra
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+var (
+	GlobRescanInterval = 5 * time.Second
+)
+
+// InTailGlob follows all files matching the glob pattern by spawning InTail for each file.
+type InTailGlob struct {
+	pattern  string
+	config   *ConfigLogfile
+	modifier *RecordModifier
+	watcher  *Watcher
+	eventCh  chan fsnotify.Event
+	tails    map[string]*InTail
+	inodes   *inodeSet
+	skipped  map[string]uint64
+}
+
+// inodeSet records inodes of files which were opened by tails.
+type inodeSet struct {
+	inodes map[uint64]bool
+	mu     sync.Mutex
+}
+
+func (s *inodeSet) add(inode uint64) {
+	if s == nil || inode == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inodes[inode] = true
+}
+
+func (s *inodeSet) has(inode uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inodes[inode]
+}
+
+// retain removes inodes which are not in the set (the files were removed).
+func (s *inodeSet) retain(inodes map[uint64]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for inode := range s.inodes {
+		if !inodes[inode] {
+			delete(s.inodes, inode)
+		}
+	}
+}
+
+func NewInTailGlob(config *ConfigLogfile, watcher *Watcher) (*InTailGlob, error) {
+	pattern, err := Rel2Abs(config.File)
+	if err != nil {
+		return nil, err
+	}
+	// validate pattern
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if err := checkRegexps(config.Format, config.Regexp, config.MultilineStart); err != nil {
+		return nil, err
+	}
+	if _, err := NewCSVParser(config.Format, config.Keys, config.Delimiter); err != nil {
+		return nil, err
+	}
+	if _, err := config.Filters(pattern); err != nil {
+		return nil, err
+	}
+	eventCh, err := watcher.WatchPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &InTailGlob{
+		pattern:  pattern,
+		config:   config,
+		modifier: NewRecordModifier(config.ConvertMap, config.TimeParse, config.TimeKey, config.TimeFormat),
+		watcher:  watcher,
+		eventCh:  eventCh,
+		tails:    make(map[string]*InTail),
+		inodes:   &inodeSet{inodes: make(map[uint64]bool)},
+		skipped:  make(map[string]uint64),
+	}, nil
+}
+
+func (g *InTailGlob) Run(c *Context) {
+	c.InputProcess.Add(1)
+	defer c.InputProcess.Done()
+
+	// files which exist at startup are treated as same as a single [[Logs]] File
+	g.rescan(c, false)
+
+	c.StartProcess.Done()
+
+	ticker := time.NewTicker(GlobRescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ControlCh:
+			log.Println("[info] shutdown in_tail glob:", g.pattern)
+			return
+		case ev := <-g.eventCh:
+			if ev.Op&fsnotify.Create == fsnotify.Create {
+				if _, ok := g.tails[ev.Name]; !ok {
+					g.spawn(c, ev.Name, true)
+				}
+			}
+		case <-ticker.C:
+			if err := g.watcher.WatchPatternDirs(g.pattern); err != nil {
+				log.Println("[warning]", err)
+			}
+			g.rescan(c, true)
+		}
+	}
+}
+
+// rescan spawns InTail for new matched files, and retires InTail for vanished files.
+func (g *InTailGlob) rescan(c *Context, readFromHead bool) {
+	matches, err := filepath.Glob(g.pattern)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+	found := make(map[string]bool, len(matches))
+	inodes := make(map[uint64]bool, len(matches))
+	for _, filename := range matches {
+		found[filename] = true
+		if stat, err := os.Stat(filename); err == nil {
+			inodes[inodeOf(stat)] = true
+		}
+		if _, ok := g.tails[filename]; !ok {
+			g.spawn(c, filename, readFromHead)
+		}
+	}
+	for filename, tail := range g.tails {
+		if !found[filename] {
+			g.retire(c, filename, tail)
+		}
+	}
+	for filename := range g.skipped {
+		if !found[filename] {
+			delete(g.skipped, filename)
+		}
+	}
+	// inodes of removed files may be reused by new files
+	g.inodes.retain(inodes)
+}
+
+func (g *InTailGlob) spawn(c *Context, filename string, readFromHead bool) {
+	if g.rotated(filename) {
+		return
+	}
+	tag := ExpandTag(g.config.Tag, filename)
+	tail, err := newInTailFile(g.config, filename, tag, g.modifier, g.watcher)
+	if err != nil {
+		log.Println("[error]", err)
+		return
+	}
+	tail.readFromHead = readFromHead
+	tail.inodes = g.inodes
+	tail.messageCh = c.MessageCh
+	tail.monitorCh = c.MonitorCh
+	g.tails[filename] = tail
+	log.Println("[info] Found", filename, "matched with", g.pattern, "tag:", tag)
+
+	c.InputProcess.Add(1)
+	tail.running.Add(1)
+	go func() {
+		defer c.InputProcess.Done()
+		defer tail.running.Done()
+		tail.follow(c)
+	}()
+}
+
+// rotated returns true if the file was already read by a tail as another name
+// (e.g. access.log was rotated to access.log.1 and both match the pattern).
+func (g *InTailGlob) rotated(filename string) bool {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	inode := inodeOf(stat)
+	if !g.inodes.has(inode) {
+		delete(g.skipped, filename)
+		return false
+	}
+	if g.skipped[filename] != inode {
+		log.Println("[info]", filename, "was rotated from a trailing file. Skip")
+		g.skipped[filename] = inode
+	}
+	return true
+}
+
+func (g *InTailGlob) retire(c *Context, filename string, tail *InTail) {
+	log.Println("[info]", filename, "was vanished. Stop trailing")
+	tail.Stop()
+	g.watcher.UnwatchFile(filename)
+	delete(g.tails, filename)
+
+	// the tail reads the rest of the file (until RotateWait elapsed) before removed
+	c.InputProcess.Add(1)
+	go func() {
+		defer c.InputProcess.Done()
+		tail.running.Wait()
+		if tail.positionFile != nil {
+			if err := tail.positionFile.Remove(filename); err != nil {
+				log.Println("[warning]", err)
+			}
+		}
+		tail.monitorCh <- &FileStat{
+			File:    filename,
+			Removed: true,
+		}
+	}()
+}
+
+// ExpandTag replaces ${basename} and ${dirname} in the tag with the path of the file.
+func ExpandTag(tag string, filename string) string {
+	if !strings.Contains(tag, "${") {
+		return tag
+	}
+	r := strings.NewReplacer(
+		"${basename}", filepath.Base(filename),
+		"${dirname}", filepath.Base(filepath.Dir(filename)),
+	)
+	return r.Replace(tag)
+}
@@ -0,0 +1,208 @@
+package hydra_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestExpandTag(t *testing.T) {
+	cases := map[string]string{
+		"foo":                    "foo",
+		"foo.${basename}":        "foo.access.log",
+		"${dirname}.${basename}": "nginx.access.log",
+	}
+	for tag, expected := range cases {
+		if got := hydra.ExpandTag(tag, "/var/log/nginx/access.log"); got != expected {
+			t.Errorf("ExpandTag(%s) got %s expected %s", tag, got, expected)
+		}
+	}
+}
+
+func TestTrailGlob(t *testing.T) {
+	hydra.GlobRescanInterval = 500 * time.Millisecond
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	fileA, _ := os.Create(filepath.Join(tmpdir, "a.log"))
+	fileB, _ := os.Create(filepath.Join(tmpdir, "b.log"))
+	ioutil.WriteFile(filepath.Join(tmpdir, "ignore.txt"), []byte{}, 0644)
+
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:       "test.${basename}",
+		File:      filepath.Join(tmpdir, "*.log"),
+		FieldName: "message",
+	}
+	if !configLogfile.IsGlob() {
+		t.Error("File must be a glob pattern")
+	}
+	c := hydra.NewContext()
+	watcher, err := hydra.NewWatcher()
+	if err != nil {
+		t.Error(err)
+	}
+	inTailGlob, err := hydra.NewInTailGlob(configLogfile, watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inTailGlob)
+	c.RunProcess(watcher)
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		fileA.WriteString("aaa\n")
+		fileB.WriteString("bbb\n")
+		// a new file is read from head
+		ioutil.WriteFile(filepath.Join(tmpdir, "c.log"), []byte("ccc\n"), 0644)
+		ioutil.WriteFile(filepath.Join(tmpdir, "ignore.txt"), []byte("xxx\n"), 0644)
+	}()
+
+	recieved := make(map[string]string)
+RECEIVE:
+	for {
+		select {
+		case rs := <-c.MessageCh:
+			for _, record := range rs.Records {
+				message, _ := record.GetData("message")
+				recieved[rs.Tag] += string(message.([]byte))
+			}
+		case <-time.After(3 * time.Second):
+			break RECEIVE
+		}
+	}
+	expected := map[string]string{
+		"test.a.log": "aaa",
+		"test.b.log": "bbb",
+		"test.c.log": "ccc",
+	}
+	if len(recieved) != len(expected) {
+		t.Errorf("unexpected recieved %#v", recieved)
+	}
+	for tag, message := range expected {
+		if recieved[tag] != message {
+			t.Errorf("tag %s got %s expected %s", tag, recieved[tag], message)
+		}
+	}
+	fileA.Close()
+	fileB.Close()
+	c.Shutdown()
+}
+
+func TestTrailGlobRemoved(t *testing.T) {
+	hydra.GlobRescanInterval = 500 * time.Millisecond
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "a.log")
+	file, _ := os.Create(path)
+	defer file.Close()
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:        "test",
+		File:       filepath.Join(tmpdir, "*.log"),
+		FieldName:  "message",
+		RotateWait: hydra.Duration{Duration: 2 * time.Second},
+	}
+	c := hydra.NewContext()
+	watcher, _ := hydra.NewWatcher()
+	inTailGlob, err := hydra.NewInTailGlob(configLogfile, watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inTailGlob)
+	c.RunProcess(watcher)
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		file.WriteString("aaa\n")
+		time.Sleep(500 * time.Millisecond)
+		os.Remove(path)
+		// written after the file vanished and the tail was stopped
+		time.Sleep(1 * time.Second)
+		file.WriteString("bbb\n")
+	}()
+
+	recieved := ""
+	removed := false
+RECEIVE:
+	for {
+		select {
+		case rs := <-c.MessageCh:
+			if removed {
+				t.Error("records must be read before the file stat is removed")
+			}
+			for _, record := range rs.Records {
+				message, _ := record.GetData("message")
+				recieved += string(message.([]byte))
+			}
+		case s := <-c.MonitorCh:
+			// other stats are shared with the tail
+			if fs, ok := s.(*hydra.FileStat); ok && fs.Removed && fs.File == path {
+				removed = true
+			}
+		case <-time.After(3 * time.Second):
+			break RECEIVE
+		}
+	}
+	if recieved != "aaabbb" {
+		t.Errorf("unexpected recieved %s", recieved)
+	}
+	if !removed {
+		t.Error("the file stat must be removed at last")
+	}
+	c.Shutdown()
+}
+
+func TestTrailGlobRotated(t *testing.T) {
+	hydra.GlobRescanInterval = 500 * time.Millisecond
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "a.log")
+	file, _ := os.Create(path)
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:       "test",
+		File:      filepath.Join(tmpdir, "*.log*"),
+		FieldName: "message",
+	}
+	c := hydra.NewContext()
+	watcher, _ := hydra.NewWatcher()
+	inTailGlob, err := hydra.NewInTailGlob(configLogfile, watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inTailGlob)
+	c.RunProcess(watcher)
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		file.WriteString("aaa\n")
+		time.Sleep(500 * time.Millisecond)
+		file.Close()
+		// the rotated file also matches the pattern
+		os.Rename(path, path+".1")
+		time.Sleep(500 * time.Millisecond)
+		ioutil.WriteFile(path, []byte("bbb\n"), 0644)
+	}()
+
+	recieved := ""
+RECEIVE:
+	for {
+		select {
+		case rs := <-c.MessageCh:
+			for _, record := range rs.Records {
+				message, _ := record.GetData("message")
+				recieved += string(message.([]byte))
+			}
+		case <-time.After(3 * time.Second):
+			break RECEIVE
+		}
+	}
+	if recieved != "aaabbb" {
+		t.Errorf("unexpected recieved %s", recieved)
+	}
+	c.Shutdown()
+}
@@ -89,3 +89,26 @@ func TestTrailRegexp(t *testing.T) {
 		}
 	}
 }
+
+func TestTrailRegexpNotCompiled(t *testing.T) {
+	// custom grok patterns are not loaded without Config.CompileGrok
+	var reg hydra.Regexp
+	if err := reg.UnmarshalText([]byte(`%{APPLOG}`)); err != nil {
+		t.Fatal(err)
+	}
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:       "test",
+		File:      "/tmp/hydra-test.log",
+		Format:    hydra.FormatRegexp,
+		Regexp:    &reg,
+		FieldName: "message",
+	}
+	if _, err := hydra.NewInTail(configLogfile, nil); err == nil {
+		t.Error("Regexp not compiled must be an error")
+	}
+	configLogfile.Format = hydra.FormatNone
+	configLogfile.MultilineStart = &reg
+	if _, err := hydra.NewInTail(configLogfile, nil); err == nil {
+		t.Error("MultilineStart not compiled must be an error")
+	}
+}
@@ -0,0 +1,147 @@
+package hydra_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestTrailRotateWait(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	file, _ := ioutil.TempFile(tmpdir, "logfile.")
+	defer os.RemoveAll(tmpdir)
+	filename := file.Name()
+
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:        "test",
+		File:       filename,
+		FieldName:  "message",
+		RotateWait: hydra.Duration{Duration: 2 * time.Second},
+	}
+	c := hydra.NewContext()
+	watcher, err := hydra.NewWatcher()
+	if err != nil {
+		t.Error(err)
+	}
+	inTail, err := hydra.NewInTail(configLogfile, watcher)
+	if err != nil {
+		t.Error(err)
+	}
+	c.RunProcess(inTail)
+	c.RunProcess(watcher)
+
+	go func() {
+		time.Sleep(1 * time.Second)
+		file.WriteString("old1\n")
+		time.Sleep(500 * time.Millisecond)
+		os.Rename(filename, filename+".1")
+		newFile, _ := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+		time.Sleep(500 * time.Millisecond)
+		// a writer still appends to the rotated file
+		file.WriteString("old2\n")
+		newFile.WriteString("new1\n")
+		time.Sleep(500 * time.Millisecond)
+		file.WriteString("old3\n")
+		file.Close()
+		newFile.WriteString("new2\n")
+		newFile.Close()
+	}()
+
+	recieved := make([]string, 0)
+RECEIVE:
+	for {
+		select {
+		case rs := <-c.MessageCh:
+			for _, record := range rs.Records {
+				message, _ := record.GetData("message")
+				recieved = append(recieved, string(message.([]byte)))
+			}
+		case <-time.After(3 * time.Second):
+			break RECEIVE
+		}
+	}
+	sort.Strings(recieved)
+	if got := strings.Join(recieved, ","); got != "new1,new2,old1,old2,old3" {
+		t.Errorf("unexpected messages %s", got)
+	}
+	c.Shutdown()
+}
+
+func TestTrailRotateWaitResume(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	file, _ := ioutil.TempFile(tmpdir, "logfile.")
+	defer os.RemoveAll(tmpdir)
+	filename := file.Name()
+
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:        "test",
+		File:       filename,
+		FieldName:  "message",
+		PosFile:    filepath.Join(tmpdir, "pos"),
+		RotateWait: hydra.Duration{Duration: 10 * time.Second},
+	}
+	run := func(write func()) []string {
+		c := hydra.NewContext()
+		watcher, _ := hydra.NewWatcher()
+		inTail, err := hydra.NewInTail(configLogfile, watcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.RunProcess(inTail)
+		c.RunProcess(watcher)
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			write()
+		}()
+		recieved := make([]string, 0)
+	RECEIVE:
+		for {
+			select {
+			case rs := <-c.MessageCh:
+				for _, record := range rs.Records {
+					message, _ := record.GetData("message")
+					recieved = append(recieved, string(message.([]byte)))
+				}
+			case <-time.After(2 * time.Second):
+				break RECEIVE
+			}
+		}
+		c.Shutdown()
+		sort.Strings(recieved)
+		return recieved
+	}
+
+	var newFile *os.File
+	got := run(func() {
+		file.WriteString("old1\n")
+		time.Sleep(500 * time.Millisecond)
+		os.Rename(filename, filename+".1")
+		newFile, _ = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+		time.Sleep(500 * time.Millisecond)
+		file.WriteString("old2\n")
+		newFile.WriteString("new1\n")
+	})
+	if s := strings.Join(got, ","); s != "new1,old1,old2" {
+		t.Errorf("unexpected messages %s", s)
+	}
+
+	// the agent was stopped in RotateWait
+	file.WriteString("old3\n")
+	newFile.WriteString("new2\n")
+
+	got = run(func() {
+		file.WriteString("old4\n")
+		newFile.WriteString("new3\n")
+	})
+	if s := strings.Join(got, ","); s != "new2,new3,old3,old4" {
+		t.Errorf("unexpected messages after resume %s", s)
+	}
+	file.Close()
+	newFile.Close()
+}
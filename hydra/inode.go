@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package hydra
+
+import (
+	"os"
+	"syscall"
+)
+
+func inodeOf(stat os.FileInfo) uint64 {
+	if s, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(s.Ino)
+	}
+	return 0
+}
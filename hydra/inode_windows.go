@@ -0,0 +1,10 @@
+package hydra
+
+import (
+	"os"
+)
+
+// inode is not available on windows. always returns 0.
+func inodeOf(stat os.FileInfo) uint64 {
+	return 0
+}
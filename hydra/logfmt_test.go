@@ -0,0 +1,71 @@
+package hydra_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+var logfmtTests = []struct {
+	line     string
+	expected map[string]interface{}
+}{
+	{
+		`level=info msg="hello world" status=200`,
+		map[string]interface{}{"level": "info", "msg": "hello world", "status": "200"},
+	},
+	{
+		`msg="say \"hi\"\tand\\ bye" path=/foo?a=b`,
+		map[string]interface{}{"msg": "say \"hi\"\\tand\\ bye", "path": "/foo?a=b"},
+	},
+	{
+		`msg="C:\dir" at=win`,
+		map[string]interface{}{"msg": `C:\dir`, "at": "win"},
+	},
+	{
+		`debug  at=web empty= service=api`,
+		map[string]interface{}{"debug": true, "at": "web", "empty": "", "service": "api"},
+	},
+	{
+		`at=info msg="unterminated`,
+		map[string]interface{}{"at": "info", "message": `at=info msg="unterminated`},
+	},
+	{
+		`=value at=info`,
+		map[string]interface{}{"message": `=value at=info`},
+	},
+	{
+		`plain text line`,
+		map[string]interface{}{"message": `plain text line`},
+	},
+}
+
+func TestNewFluentRecordLogfmt(t *testing.T) {
+	for _, tc := range logfmtTests {
+		r := hydra.NewFluentRecordLogfmt("message", []byte(tc.line))
+		if len(r.Data) != len(tc.expected) {
+			t.Errorf("%s: unexpected record %#v", tc.line, r.Data)
+			continue
+		}
+		for k, v := range tc.expected {
+			if r.Data[k] != v {
+				t.Errorf("%s: %s expected %#v got %#v", tc.line, k, v, r.Data[k])
+			}
+		}
+	}
+}
+
+func TestNewFluentRecordSetLogfmt(t *testing.T) {
+	mod := hydra.NewRecordModifier(hydra.NewConvertMap("status:integer"), false, "", "")
+	rs := hydra.NewFluentRecordSet("dummy", "message", hydra.FormatLogfmt, mod, nil, []byte("status=200\nstatus=404 ok"))
+	if len(rs.Records) != 2 {
+		t.Fatalf("unexpected records %#v", rs.Records)
+	}
+	if s, _ := rs.Records[1].GetData("status"); s != int64(404) {
+		t.Errorf("unexpected status %#v", s)
+	}
+	var f hydra.FileFormat
+	if err := f.UnmarshalText([]byte("logfmt")); err != nil || f != hydra.FormatLogfmt {
+		t.Errorf("logfmt format must be available %s %s", f, err)
+	}
+}
@@ -0,0 +1,235 @@
+package hydra
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const (
+	FilterTypeMask         = "mask"
+	MaskMethodRedact       = "redact"
+	MaskMethodHMAC         = "hmac"
+	DefaultMaskReplacement = "[REDACTED]"
+)
+
+// MaskDetector detects PII by Pattern. Matches are masked only when Validate returns true (if defined).
+type MaskDetector struct {
+	Pattern  *regexp.Regexp
+	Validate func(string) bool
+}
+
+// MaskDetectors is the built-in detectors of PII.
+var MaskDetectors = map[string]*MaskDetector{
+	"email": {
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	},
+	"credit_card": {
+		Pattern:  regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		Validate: luhn,
+	},
+	"ipv4": {
+		Pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\b`),
+	},
+	"ipv6": {
+		// a whole token of word characters and colons (e.g. not a part of "std::vector"), may end with IPv4
+		Pattern:  regexp.MustCompile(`[\w:]*:[\w:]*(?:\.[\w:]+)*`),
+		Validate: isIPv6,
+	},
+}
+
+// isIPv6 returns true if s is an IPv6 address which has two or more groups (e.g. not "::1").
+func isIPv6(s string) bool {
+	groups := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	return groups >= 2 && net.ParseIP(s) != nil
+}
+
+// luhn returns true if digits in s pass the Luhn checksum (for credit card numbers).
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+type maskRule struct {
+	name     string
+	pattern  *regexp.Regexp
+	validate func(string) bool
+}
+
+// MaskFilter masks PII in fields of records by redaction or keyed HMAC-SHA256 hashing.
+type MaskFilter struct {
+	name        string
+	tagPattern  *TagPattern
+	fields      []string
+	rules       []*maskRule
+	method      string
+	replacement string
+	hmacKey     []byte
+}
+
+func NewMaskFilter(config *ConfigMask) (*MaskFilter, error) {
+	f := &MaskFilter{
+		name:        config.Name,
+		tagPattern:  config.TagPattern,
+		fields:      config.Fields,
+		method:      config.Method,
+		replacement: config.Replacement,
+		hmacKey:     []byte(config.HMACKey),
+	}
+	switch f.method {
+	case "":
+		f.method = MaskMethodRedact
+	case MaskMethodRedact:
+	case MaskMethodHMAC:
+		if len(f.hmacKey) == 0 {
+			return nil, fmt.Errorf("HMACKey is required for Mask Method hmac")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported Mask Method %s", f.method)
+	}
+	if f.replacement == "" {
+		f.replacement = DefaultMaskReplacement
+	}
+	detectors := config.Detectors
+	if len(detectors) == 0 && len(config.Patterns) == 0 {
+		detectors = []string{"email", "credit_card", "ipv4", "ipv6"}
+	}
+	for _, name := range detectors {
+		d, ok := MaskDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown Mask Detector %s", name)
+		}
+		f.rules = append(f.rules, &maskRule{name: name, pattern: d.Pattern, validate: d.Validate})
+	}
+	for i, p := range config.Patterns {
+		if p == nil || p.Regexp == nil {
+			return nil, fmt.Errorf("invalid Mask Patterns[%d]", i)
+		}
+		f.rules = append(f.rules, &maskRule{name: fmt.Sprintf("pattern[%d]", i), pattern: p.Regexp})
+	}
+	if f.name == "" {
+		f.name = FilterTypeMask
+	}
+	return f, nil
+}
+
+func (f *MaskFilter) Filter(rs *fluent.FluentRecordSet, monitorCh chan Stat) []*fluent.FluentRecordSet {
+	if !f.tagPattern.Match(rs.Tag) {
+		return []*fluent.FluentRecordSet{rs}
+	}
+	stat := &MaskStat{Tag: rs.Tag, Detectors: make(map[string]int64)}
+	for _, record := range rs.Records {
+		counts := make(map[string]int64)
+		switch r := record.(type) {
+		case *fluent.TinyFluentMessage:
+			if f.maskField(r.FieldName) {
+				r.Message = []byte(f.maskString(string(r.Message), counts))
+			}
+		default:
+			data := record.GetAllData()
+			for key, value := range data {
+				if f.maskField(key) {
+					data[key] = f.maskValue(value, counts)
+				}
+			}
+		}
+		if len(counts) > 0 {
+			stat.Records++
+		}
+		for name, n := range counts {
+			stat.Redactions += n
+			stat.Detectors[name] += n
+		}
+	}
+	monitorCh <- &FilterStat{
+		Name:   f.name,
+		Type:   FilterTypeMask,
+		Passed: int64(len(rs.Records)),
+	}
+	monitorCh <- stat
+	return []*fluent.FluentRecordSet{rs}
+}
+
+// maskField returns true if the field is to be masked. All fields are masked when fields are not defined.
+func (f *MaskFilter) maskField(key string) bool {
+	if len(f.fields) == 0 {
+		return true
+	}
+	for _, field := range f.fields {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+// maskValue masks strings in the value, including nested maps and arrays.
+func (f *MaskFilter) maskValue(value interface{}, counts map[string]int64) interface{} {
+	switch v := value.(type) {
+	case string:
+		return f.maskString(v, counts)
+	case []byte:
+		return []byte(f.maskString(string(v), counts))
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = f.maskValue(value, counts)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			v[key] = f.maskValue(value, counts)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = f.maskValue(value, counts)
+		}
+	}
+	return value
+}
+
+func (f *MaskFilter) maskString(s string, counts map[string]int64) string {
+	for _, rule := range f.rules {
+		s = rule.pattern.ReplaceAllStringFunc(s, func(m string) string {
+			if rule.validate != nil && !rule.validate(m) {
+				return m
+			}
+			counts[rule.name]++
+			return f.mask(m)
+		})
+	}
+	return s
+}
+
+func (f *MaskFilter) mask(s string) string {
+	if f.method == MaskMethodHMAC {
+		mac := hmac.New(sha256.New, f.hmacKey)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	return f.replacement
+}
@@ -0,0 +1,148 @@
+package hydra_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"testing"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestMaskFilterMessage(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	f, err := hydra.NewMaskFilter(&hydra.ConfigMask{Name: "pii"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := hydra.NewFluentRecordSet("app", "message", hydra.FormatNone, nil, nil, []byte(
+		"login user=foo@example.com from 192.168.1.10\n"+
+			"paid by 4111 1111 1111 1111 order=1234567890123\n"+
+			"peer 2001:db8::1 at 12:34:56 mac 00:1a:2b:3c:4d:5e",
+	))
+	sets := f.Filter(rs, monitorCh)
+	if len(sets) != 1 {
+		t.Fatalf("unexpected record sets %#v", sets)
+	}
+	expected := []string{
+		"login user=[REDACTED] from [REDACTED]",
+		"paid by [REDACTED] order=1234567890123", // fails the Luhn check
+		"peer [REDACTED] at 12:34:56 mac 00:1a:2b:3c:4d:5e",
+	}
+	for i, r := range sets[0].Records {
+		m, ok := r.(*fluent.TinyFluentMessage)
+		if !ok {
+			t.Fatalf("records[%d] must be kept as a message %#v", i, r)
+		}
+		if string(m.Message) != expected[i] {
+			t.Errorf("records[%d] expected %q got %q", i, expected[i], m.Message)
+		}
+	}
+	if s := (<-monitorCh).(*hydra.FilterStat); s.Name != "pii" || s.Type != "mask" || s.Passed != 3 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+	stats := &hydra.Stats{}
+	(<-monitorCh).ApplyTo(stats)
+	s := stats.Masks["app"]
+	if s == nil || s.Records != 3 || s.Redactions != 4 {
+		t.Fatalf("unexpected mask stat %#v", s)
+	}
+	for name, n := range map[string]int64{"email": 1, "ipv4": 1, "credit_card": 1, "ipv6": 1} {
+		if s.Detectors[name] != n {
+			t.Errorf("detector %s expected %d got %d", name, n, s.Detectors[name])
+		}
+	}
+}
+
+func TestMaskFilterIPv6(t *testing.T) {
+	f, err := hydra.NewMaskFilter(&hydra.ConfigMask{Detectors: []string{"ipv6"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, expected := range map[string]string{
+		"from 2001:db8::1.":              "from [REDACTED].",
+		"[fe80::1ff:fe23:4567:890a]:443": "[[REDACTED]]:443",
+		"mapped ::ffff:192.0.2.1 ok":     "mapped [REDACTED] ok",
+		"dead:beef::":                    "[REDACTED]",
+		"std::vector<int>":               "std::vector<int>",
+		"ActiveRecord::Base.connection":  "ActiveRecord::Base.connection",
+		"Error::Database: failed":        "Error::Database: failed",
+		"localhost ::1 at 12:34:56":      "localhost ::1 at 12:34:56",
+		"mac 00:1a:2b:3c:4d:5e":          "mac 00:1a:2b:3c:4d:5e",
+		"a::b::c and Foo::Bar::Baz::Qux": "a::b::c and Foo::Bar::Baz::Qux",
+	} {
+		monitorCh := make(chan hydra.Stat, 10)
+		rs := hydra.NewFluentRecordSet("app", "message", hydra.FormatNone, nil, nil, []byte(line))
+		m := f.Filter(rs, monitorCh)[0].Records[0].(*fluent.TinyFluentMessage)
+		if string(m.Message) != expected {
+			t.Errorf("%q expected %q got %q", line, expected, m.Message)
+		}
+	}
+}
+
+func TestMaskFilterHMAC(t *testing.T) {
+	monitorCh := make(chan hydra.Stat, 10)
+	tp, _ := hydra.NewTagPattern("access.**")
+	f, err := hydra.NewMaskFilter(&hydra.ConfigMask{
+		TagPattern: tp,
+		Fields:     []string{"user", "params"},
+		Detectors:  []string{"email"},
+		Patterns:   []*hydra.Regexp{{Regexp: regexp.MustCompile(`token=\w+`)}},
+		Method:     "hmac",
+		HMACKey:    "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func(s string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	rs := &fluent.FluentRecordSet{
+		Tag: "access.app",
+		Records: []fluent.FluentRecordType{
+			&fluent.TinyFluentRecord{Data: map[string]interface{}{
+				"user":   "foo@example.com",
+				"params": map[string]interface{}{"q": []interface{}{"token=abc", "bar@example.com"}},
+				"from":   "baz@example.com", // not in Fields
+			}},
+		},
+	}
+	sets := f.Filter(rs, monitorCh)
+	data := sets[0].Records[0].GetAllData()
+	if data["user"] != hash("foo@example.com") {
+		t.Errorf("unexpected user %v", data["user"])
+	}
+	q := data["params"].(map[string]interface{})["q"].([]interface{})
+	if q[0] != hash("token=abc") || q[1] != hash("bar@example.com") {
+		t.Errorf("unexpected params %v", q)
+	}
+	if data["from"] != "baz@example.com" {
+		t.Errorf("unexpected from %v", data["from"])
+	}
+	<-monitorCh
+	if s := (<-monitorCh).(*hydra.MaskStat); s.Records != 1 || s.Redactions != 3 || s.Detectors["pattern[0]"] != 1 {
+		t.Errorf("unexpected stat %#v", s)
+	}
+
+	// not applied to other tags
+	rs = hydra.NewFluentRecordSet("app", "user", hydra.FormatNone, nil, nil, []byte("foo@example.com"))
+	if sets := f.Filter(rs, monitorCh); string(sets[0].Records[0].(*fluent.TinyFluentMessage).Message) != "foo@example.com" {
+		t.Errorf("unexpected record %#v", sets[0].Records[0])
+	}
+}
+
+func TestMaskFilterConfigErrors(t *testing.T) {
+	for _, config := range []*hydra.ConfigMask{
+		{Method: "hmac"},
+		{Method: "rot13"},
+		{Detectors: []string{"phone"}},
+	} {
+		if _, err := hydra.NewMaskFilter(config); err == nil {
+			t.Errorf("config %#v must be an error", config)
+		}
+	}
+}
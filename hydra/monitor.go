@@ -18,10 +18,17 @@ const (
 )
 
 type Stats struct {
-	Sent     map[string]*SentStat `json:"sent"`
-	Files    map[string]*FileStat `json:"files"`
-	Servers  []*ServerStat        `json:"servers"`
-	Receiver *ReceiverStat        `json:"receiver"`
+	Sent     map[string]*SentStat   `json:"sent"`
+	Files    map[string]*FileStat   `json:"files"`
+	Servers  []*ServerStat          `json:"servers"`
+	Receiver *ReceiverStat          `json:"receiver"`
+	Buffer   *BufferStat            `json:"buffer,omitempty"`
+	HTTP     *HTTPStat              `json:"http,omitempty"`
+	Execs    map[string]*ExecStat   `json:"execs,omitempty"`
+	Filters  map[string]*FilterStat `json:"filters,omitempty"`
+	Routes   map[string]*RouteStat  `json:"routes,omitempty"`
+	Outputs  map[string]*OutputStat `json:"outputs,omitempty"`
+	Masks    map[string]*MaskStat   `json:"masks,omitempty"`
 	mu       sync.Mutex
 }
 
@@ -30,61 +37,217 @@ type Stat interface {
 }
 
 type ServerStat struct {
-	Index   int    `json:"-"`
-	Address string `json:"address"`
-	Alive   bool   `json:"alive"`
-	Error   string `json:"error"`
+	Index   int     `json:"-"`
+	Output  string  `json:"-"`
+	Address string  `json:"address"`
+	Alive   bool    `json:"alive"`
+	Error   string  `json:"error"`
+	Policy  string  `json:"policy"`
+	Weight  int     `json:"weight"`
+	Standby bool    `json:"standby"`
+	Sent    int64   `json:"sent"`
+	Share   float64 `json:"share"`
 }
 
 type SentStat struct {
-	Tag      string `json:"-"`
-	Messages int64  `json:"messages"`
-	Bytes    int64  `json:"bytes"`
-	Sents    int64  `json:"sents"`
+	Tag              string           `json:"-"`
+	Messages         int64            `json:"messages"`
+	Bytes            int64            `json:"bytes"`
+	RawBytes         int64            `json:"raw_bytes"`
+	Sents            int64            `json:"sents"`
+	AvgChunkBytes    float64          `json:"avg_chunk_bytes"`
+	AvgChunkMessages float64          `json:"avg_chunk_messages"`
+	FlushReasons     map[string]int64 `json:"flush_reasons,omitempty"`
 }
 
 type FileStat struct {
+	Tag               string `json:"tag"`
+	File              string `json:"-"`
+	Position          int64  `json:"position"`
+	PersistedPosition int64  `json:"persisted_position"`
+	Error             string `json:"error"`
+	Rotated           bool   `json:"rotated,omitempty"`
+	Removed           bool   `json:"-"`
+}
+
+type ReceiverStat struct {
+	Address              string `json:"address"`
+	Connections          int    `json:"-"`
+	TotalConnections     int    `json:"total_connections"`
+	CurrentConnections   int    `json:"current_connections"`
+	Messages             int64  `json:"messages"`
+	Disposed             int64  `json:"disposed"`
+	Buffered             int64  `json:"buffered"`
+	MaxBufferMessages    int64  `json:"max_buffer_messages"`
+	Acked                int64  `json:"acked"`
+	Unacked              int64  `json:"unacked"`
+	Rejected             int64  `json:"rejected"`
+	TLSHandshakeFailures int64  `json:"tls_handshake_failures"`
+}
+
+type BufferStat struct {
+	Output         string  `json:"-"`
+	Path           string  `json:"path"`
+	Chunks         int     `json:"chunks"`
+	Bytes          int64   `json:"bytes"`
+	MaxBytes       int64   `json:"max_bytes"`
+	OldestChunkAge float64 `json:"oldest_chunk_age"`
+	Disposed       int64   `json:"disposed"`
+}
+
+type HTTPStat struct {
+	Address  string `json:"address"`
+	Requests int64  `json:"requests"`
+	Records  int64  `json:"records"`
+	Errors   int64  `json:"errors"`
+	TooLarge int64  `json:"too_large"`
+}
+
+// ExecStat is a snapshot of an exec input. ExitCode and Stderr are of the last run.
+type ExecStat struct {
 	Tag      string `json:"tag"`
-	File     string `json:"-"`
-	Position int64  `json:"position"`
+	Command  string `json:"-"`
+	Runs     int64  `json:"runs"`
+	Failures int64  `json:"failures"`
+	Records  int64  `json:"records"`
+	ExitCode int    `json:"exit_code"`
+	Stderr   string `json:"stderr"`
 	Error    string `json:"error"`
 }
 
-type ReceiverStat struct {
-	Address            string `json:"address"`
-	Connections        int    `json:"-"`
-	TotalConnections   int    `json:"total_connections"`
-	CurrentConnections int    `json:"current_connections"`
-	Messages           int64  `json:"messages"`
-	Disposed           int64  `json:"disposed"`
-	Buffered           int64  `json:"buffered"`
-	MaxBufferMessages  int64  `json:"max_buffer_messages"`
+// RouteStat is counts of records routed to outputs by tags. Dropped records matched no outputs.
+// Disposed records overflowed the queue of a slow output.
+type RouteStat struct {
+	Tag      string           `json:"-"`
+	Output   string           `json:"-"`
+	Records  int64            `json:"-"`
+	Outputs  map[string]int64 `json:"outputs"`
+	Dropped  int64            `json:"dropped"`
+	Disposed int64            `json:"disposed"`
+}
+
+// OutputStat is stats of an output. "servers" and "buffer" of Stats are of the default output.
+// Records are counted by the output whether routed or not.
+type OutputStat struct {
+	Name    string        `json:"-"`
+	Servers []*ServerStat `json:"servers"`
+	Buffer  *BufferStat   `json:"buffer,omitempty"`
+	Records int64         `json:"records"`
+}
+
+// MaskStat is counts of masked PII by tags. Records is the number of records which had PII.
+type MaskStat struct {
+	Tag        string           `json:"-"`
+	Records    int64            `json:"records"`
+	Redactions int64            `json:"redactions"`
+	Detectors  map[string]int64 `json:"detectors"`
+}
+
+type FilterStat struct {
+	Name      string `json:"-"`
+	Type      string `json:"type"`
+	Passed    int64  `json:"passed"`
+	Dropped   int64  `json:"dropped"`
+	Rewritten int64  `json:"rewritten,omitempty"`
 }
 
 func (s *FileStat) ApplyTo(ss *Stats) {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
+	if s.Removed {
+		delete(ss.Files, s.File)
+		return
+	}
 	ss.Files[s.File] = s
 }
 
 func (s *ServerStat) ApplyTo(ss *Stats) {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
-	ss.Servers[s.Index] = s
+	o := ss.output(s.Output)
+	o.Servers = setServerStat(o.Servers, s)
+	if isDefaultOutput(s.Output) {
+		ss.Servers = setServerStat(ss.Servers, s)
+	}
+}
+
+func setServerStat(servers []*ServerStat, s *ServerStat) []*ServerStat {
+	for len(servers) <= s.Index {
+		servers = append(servers, nil)
+	}
+	servers[s.Index] = s
+	return servers
+}
+
+func isDefaultOutput(name string) bool {
+	return name == "" || name == DefaultOutputName
+}
+
+// output returns stats of the output. must be called with lock.
+func (ss *Stats) output(name string) *OutputStat {
+	if name == "" {
+		name = DefaultOutputName
+	}
+	if ss.Outputs == nil {
+		ss.Outputs = make(map[string]*OutputStat)
+	}
+	o, ok := ss.Outputs[name]
+	if !ok {
+		o = &OutputStat{Servers: make([]*ServerStat, 0)}
+		ss.Outputs[name] = o
+	}
+	return o
 }
 
 func (s *SentStat) ApplyTo(ss *Stats) {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
-	if _s, ok := ss.Sent[s.Tag]; ok {
-		_s.Messages += s.Messages
-		_s.Bytes += s.Bytes
-		_s.Sents += s.Sents
-	} else {
-		ss.Sent[s.Tag] = s
+	_s, ok := ss.Sent[s.Tag]
+	if !ok {
+		_s = &SentStat{Tag: s.Tag}
+		ss.Sent[s.Tag] = _s
+	}
+	_s.Messages += s.Messages
+	_s.Bytes += s.Bytes
+	_s.RawBytes += s.RawBytes
+	_s.Sents += s.Sents
+	for reason, n := range s.FlushReasons {
+		if _s.FlushReasons == nil {
+			_s.FlushReasons = make(map[string]int64)
+		}
+		_s.FlushReasons[reason] += n
+	}
+	if _s.Sents > 0 {
+		_s.AvgChunkBytes = float64(_s.Bytes) / float64(_s.Sents)
+		_s.AvgChunkMessages = float64(_s.Messages) / float64(_s.Sents)
 	}
 }
 
+func (s *BufferStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.output(s.Output).Buffer = s
+	if isDefaultOutput(s.Output) {
+		ss.Buffer = s
+	}
+}
+
+func (s *HTTPStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.HTTP == nil {
+		ss.HTTP = s
+		return
+	}
+	if s.Address != "" {
+		ss.HTTP.Address = s.Address
+	}
+	ss.HTTP.Requests += s.Requests
+	ss.HTTP.Records += s.Records
+	ss.HTTP.Errors += s.Errors
+	ss.HTTP.TooLarge += s.TooLarge
+}
+
 func (s *ReceiverStat) ApplyTo(ss *Stats) {
 	if ss.Receiver == nil {
 		ss.Receiver = s
@@ -101,6 +264,10 @@ func (s *ReceiverStat) ApplyTo(ss *Stats) {
 	rs.Messages += s.Messages
 	rs.Disposed += s.Disposed
 	rs.Buffered = s.Buffered
+	rs.Acked += s.Acked
+	rs.Unacked += s.Unacked
+	rs.Rejected += s.Rejected
+	rs.TLSHandshakeFailures += s.TLSHandshakeFailures
 }
 
 func (ss *Stats) WriteJSON(w http.ResponseWriter) {
@@ -117,6 +284,71 @@ func (ss *Stats) Run(ch chan Stat) {
 	}
 }
 
+func (s *ExecStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.Execs == nil {
+		ss.Execs = make(map[string]*ExecStat)
+	}
+	ss.Execs[s.Command] = s
+}
+
+func (s *FilterStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.Filters == nil {
+		ss.Filters = make(map[string]*FilterStat)
+	}
+	if _, ok := ss.Filters[s.Name]; !ok {
+		ss.Filters[s.Name] = &FilterStat{Name: s.Name, Type: s.Type}
+	}
+	ss.Filters[s.Name].Passed += s.Passed
+	ss.Filters[s.Name].Dropped += s.Dropped
+	ss.Filters[s.Name].Rewritten += s.Rewritten
+}
+
+func (s *OutputStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.output(s.Name).Records += s.Records
+}
+
+func (s *RouteStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.Routes == nil {
+		ss.Routes = make(map[string]*RouteStat)
+	}
+	rs, ok := ss.Routes[s.Tag]
+	if !ok {
+		rs = &RouteStat{Tag: s.Tag, Outputs: make(map[string]int64)}
+		ss.Routes[s.Tag] = rs
+	}
+	if s.Output != "" {
+		rs.Outputs[s.Output] += s.Records
+	}
+	rs.Dropped += s.Dropped
+	rs.Disposed += s.Disposed
+}
+
+func (s *MaskStat) ApplyTo(ss *Stats) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.Masks == nil {
+		ss.Masks = make(map[string]*MaskStat)
+	}
+	ms, ok := ss.Masks[s.Tag]
+	if !ok {
+		ms = &MaskStat{Tag: s.Tag, Detectors: make(map[string]int64)}
+		ss.Masks[s.Tag] = ms
+	}
+	ms.Records += s.Records
+	ms.Redactions += s.Redactions
+	for name, n := range s.Detectors {
+		ms.Detectors[name] += n
+	}
+}
+
 type Monitor struct {
 	stats     *Stats
 	address   string
@@ -129,7 +361,13 @@ func NewMonitor(config *Config) (*Monitor, error) {
 	stats := &Stats{
 		Sent:    make(map[string]*SentStat),
 		Files:   make(map[string]*FileStat),
-		Servers: make([]*ServerStat, len(config.Servers)),
+		Servers: make([]*ServerStat, 0),
+	}
+	for _, o := range config.Outputs {
+		stats.output(o.Name).Servers = make([]*ServerStat, len(o.Servers))
+		if isDefaultOutput(o.Name) {
+			stats.Servers = make([]*ServerStat, len(o.Servers))
+		}
 	}
 	monitor := &Monitor{
 		stats: stats,
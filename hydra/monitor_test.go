@@ -72,3 +72,38 @@ func TestMonitorServer(t *testing.T) {
 
 	log.Println(string(body))
 }
+
+func TestOutputStats(t *testing.T) {
+	stats := &hydra.Stats{}
+	for _, s := range []hydra.Stat{
+		&hydra.ServerStat{Index: 1, Output: "audit", Address: "audit2:24224"},
+		&hydra.ServerStat{Index: 0, Address: "default1:24224"},
+		&hydra.BufferStat{Output: "audit", Path: "/tmp/audit"},
+		&hydra.BufferStat{Output: "default", Path: "/tmp/default"},
+		&hydra.RouteStat{Tag: "audit.login", Output: "audit", Records: 3},
+		&hydra.RouteStat{Tag: "audit.login", Output: "default", Records: 3},
+		&hydra.RouteStat{Tag: "unknown", Dropped: 2},
+		&hydra.OutputStat{Name: "audit", Records: 3},
+		&hydra.OutputStat{Name: "default", Records: 3},
+		&hydra.OutputStat{Records: 5}, // not routed
+	} {
+		s.ApplyTo(stats)
+	}
+	if o := stats.Outputs["audit"]; o == nil || len(o.Servers) != 2 || o.Servers[0] != nil ||
+		o.Servers[1].Address != "audit2:24224" || o.Buffer.Path != "/tmp/audit" || o.Records != 3 {
+		t.Errorf("unexpected audit output stat %#v", o)
+	}
+	if o := stats.Outputs["default"]; o == nil || len(o.Servers) != 1 || o.Buffer.Path != "/tmp/default" || o.Records != 8 {
+		t.Errorf("unexpected default output stat %#v", o)
+	}
+	// servers and buffer of the default output
+	if len(stats.Servers) != 1 || stats.Servers[0].Address != "default1:24224" || stats.Buffer.Path != "/tmp/default" {
+		t.Errorf("unexpected stats %#v", stats)
+	}
+	if r := stats.Routes["audit.login"]; r == nil || r.Outputs["audit"] != 3 || r.Outputs["default"] != 3 {
+		t.Errorf("unexpected route stat %#v", r)
+	}
+	if r := stats.Routes["unknown"]; r == nil || r.Dropped != 2 || len(r.Outputs) != 0 {
+		t.Errorf("unexpected route stat %#v", r)
+	}
+}
@@ -0,0 +1,112 @@
+package hydra
+
+import (
+	"time"
+)
+
+const (
+	DefaultMultilineMaxLines      = 1000
+	DefaultMultilineMaxBytes      = 1024 * 1024
+	DefaultMultilineFlushInterval = 3 * time.Second
+)
+
+// Multiline assembles lines into events. An event begins with a line matching the start regexp.
+type Multiline struct {
+	start         *Regexp
+	maxLines      int
+	maxBytes      int
+	flushInterval time.Duration
+	buf           []byte
+	lines         int
+	updatedAt     time.Time
+}
+
+func NewMultiline(config *ConfigLogfile) *Multiline {
+	if config.MultilineStart == nil || config.MultilineStart.Regexp == nil {
+		return nil
+	}
+	m := &Multiline{
+		start:         config.MultilineStart,
+		maxLines:      config.MultilineMaxLines,
+		maxBytes:      config.MultilineMaxBytes,
+		flushInterval: config.MultilineFlushInterval.Duration,
+	}
+	if m.maxLines == 0 {
+		m.maxLines = DefaultMultilineMaxLines
+	}
+	if m.maxBytes == 0 {
+		m.maxBytes = DefaultMultilineMaxBytes
+	}
+	if m.flushInterval == 0 {
+		m.flushInterval = DefaultMultilineFlushInterval
+	}
+	return m
+}
+
+// New returns an empty Multiline which has the same settings.
+func (m *Multiline) New() *Multiline {
+	if m == nil {
+		return nil
+	}
+	return &Multiline{
+		start:         m.start,
+		maxLines:      m.maxLines,
+		maxBytes:      m.maxBytes,
+		flushInterval: m.flushInterval,
+	}
+}
+
+// Feed appends lines and returns completed events.
+func (m *Multiline) Feed(lines [][]byte) [][]byte {
+	events := make([][]byte, 0)
+	for _, line := range lines {
+		if m.start.Match(line) || m.exceeds(line) {
+			if e := m.take(); e != nil {
+				events = append(events, e)
+			}
+		}
+		if m.lines > 0 {
+			m.buf = append(m.buf, LineSeparator...)
+		}
+		m.buf = append(m.buf, line...)
+		m.lines++
+	}
+	m.updatedAt = time.Now()
+	return events
+}
+
+func (m *Multiline) exceeds(line []byte) bool {
+	if m.lines == 0 {
+		return false
+	}
+	return (m.maxLines > 0 && m.lines+1 > m.maxLines) ||
+		(m.maxBytes > 0 && len(m.buf)+len(line)+1 > m.maxBytes)
+}
+
+// Expired returns true when a pending event was not updated in flush interval.
+func (m *Multiline) Expired() bool {
+	return m.lines > 0 && time.Now().After(m.updatedAt.Add(m.flushInterval))
+}
+
+// Pending returns bytes length of the pending event in the file (includes the last line separator).
+func (m *Multiline) Pending() int {
+	if m.lines == 0 {
+		return 0
+	}
+	return len(m.buf) + len(LineSeparator)
+}
+
+// Flush returns the pending event.
+func (m *Multiline) Flush() []byte {
+	return m.take()
+}
+
+func (m *Multiline) take() []byte {
+	if m.lines == 0 {
+		return nil
+	}
+	e := m.buf
+	m.buf = make([]byte, 0, len(e))
+	m.lines = 0
+	return e
+}
@@ -0,0 +1,129 @@
+package hydra_test
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+var (
+	MultilineLogs = []string{
+		"2016-01-01 00:00:00 INFO start\n",
+		"2016-01-01 00:00:01 ERROR java.lang.RuntimeException: oops\n",
+		"\tat com.example.Foo.bar(Foo.java:10)\n",
+		"\tat com.example.Foo.main(Foo.java:3)\n",
+		"2016-01-01 00:00:02 INFO recovered\n",
+		"2016-01-01 00:00:03 INFO last\n",
+		"\tcontinued\n",
+	}
+	MultilineEvents = []string{
+		"2016-01-01 00:00:00 INFO start",
+		"2016-01-01 00:00:01 ERROR java.lang.RuntimeException: oops\n\tat com.example.Foo.bar(Foo.java:10)\n\tat com.example.Foo.main(Foo.java:3)",
+		"2016-01-01 00:00:02 INFO recovered",
+		"2016-01-01 00:00:03 INFO last\n\tcontinued", // flushed by MultilineFlushInterval
+	}
+)
+
+func newMultilineConfig() *hydra.ConfigLogfile {
+	return &hydra.ConfigLogfile{
+		MultilineStart: &hydra.Regexp{Regexp: regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)},
+	}
+}
+
+func splitLines(s string) [][]byte {
+	lines := make([][]byte, 0)
+	for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		lines = append(lines, []byte(line))
+	}
+	return lines
+}
+
+func TestMultilineFeed(t *testing.T) {
+	m := hydra.NewMultiline(newMultilineConfig())
+	events := m.Feed(splitLines(strings.Join(MultilineLogs[0:3], "")))
+	if len(events) != 1 || string(events[0]) != MultilineEvents[0] {
+		t.Errorf("unexpected events %q", events)
+	}
+	if p := m.Pending(); p != len(MultilineLogs[1])+len(MultilineLogs[2]) {
+		t.Errorf("unexpected pending bytes %d", p)
+	}
+	events = m.Feed(splitLines(strings.Join(MultilineLogs[3:], "")))
+	if len(events) != 2 || string(events[0]) != MultilineEvents[1] || string(events[1]) != MultilineEvents[2] {
+		t.Errorf("unexpected events %q", events)
+	}
+	if e := m.Flush(); string(e) != MultilineEvents[3] {
+		t.Errorf("unexpected flushed event %q", e)
+	}
+	if m.Pending() != 0 || m.Flush() != nil {
+		t.Error("pending event must be empty after flushed")
+	}
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	config := newMultilineConfig()
+	config.MultilineMaxLines = 2
+	m := hydra.NewMultiline(config)
+	events := m.Feed(splitLines(strings.Join(MultilineLogs[1:4], "")))
+	if len(events) != 1 || string(events[0]) != strings.Join(strings.Split(MultilineEvents[1], "\n")[0:2], "\n") {
+		t.Errorf("unexpected events %q", events)
+	}
+	if e := m.Flush(); string(e) != "\tat com.example.Foo.main(Foo.java:3)" {
+		t.Errorf("unexpected flushed event %q", e)
+	}
+}
+
+func TestTrailMultiline(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	file, _ := ioutil.TempFile(tmpdir, "logfile.")
+	defer os.RemoveAll(tmpdir)
+
+	configLogfile := newMultilineConfig()
+	configLogfile.Tag = "test"
+	configLogfile.File = file.Name()
+	configLogfile.FieldName = "message"
+	configLogfile.MultilineFlushInterval = hydra.Duration{Duration: 500 * time.Millisecond}
+
+	c := hydra.NewContext()
+	watcher, err := hydra.NewWatcher()
+	if err != nil {
+		t.Error(err)
+	}
+	inTail, err := hydra.NewInTail(configLogfile, watcher)
+	if err != nil {
+		t.Error(err)
+	}
+	c.RunProcess(inTail)
+	c.RunProcess(watcher)
+	go func() {
+		time.Sleep(1 * time.Second)
+		fileWriter(t, file, MultilineLogs)
+	}()
+
+	recieved := make([]string, 0)
+RECEIVE:
+	for {
+		select {
+		case rs := <-c.MessageCh:
+			for _, record := range rs.Records {
+				message, _ := record.GetData("message")
+				recieved = append(recieved, string(message.([]byte)))
+			}
+		case <-time.After(3 * time.Second):
+			break RECEIVE
+		}
+	}
+	if len(recieved) != len(MultilineEvents) {
+		t.Errorf("unexpected events %q", recieved)
+		return
+	}
+	for i, e := range MultilineEvents {
+		if recieved[i] != e {
+			t.Errorf("event %d got %q expected %q", i, recieved[i], e)
+		}
+	}
+}
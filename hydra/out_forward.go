@@ -1,30 +1,76 @@
 package hydra
 
 import (
+	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/fujiwara/fluent-agent-hydra/fluent"
 )
 
 type OutForward struct {
-	loggers    []*fluent.Fluent
-	messageCh  chan *fluent.FluentRecordSet
-	monitorCh  chan Stat
-	sent       int64
-	RoundRobin bool
+	loggers            []*fluent.Fluent
+	servers            []*ConfigServer
+	weighted           bool
+	currentWeights     []int
+	sents              []int64
+	heartbeaters       []*Heartbeater
+	stopCh             chan interface{}
+	messageCh          chan *fluent.FluentRecordSet
+	monitorCh          chan Stat
+	sent               int64
+	Name               string
+	Input              chan *fluent.FluentRecordSet
+	RoundRobin         bool
+	RequireAckResponse bool
+	AckResponseTimeout time.Duration
+	Buffer             *FileBuffer
+	FlushInterval      time.Duration
+	ChunkMaxRecords    int
+	ChunkMaxBytes      int
 }
 
 const (
 	serverHealthCheckInterval = 3 * time.Second
 	maxKeepAliveSentCount     = 100
+	DefaultServerWeight       = 60
+	minFlushCheckInterval     = 10 * time.Millisecond
+)
+
+// server selection policies
+const (
+	PolicyPrimary    = "primary"
+	PolicyRoundRobin = "round_robin"
+	PolicyWeighted   = "weighted"
 )
 
 // OutForward ... recieve FluentRecordSet from channel, and send it to passed loggers until success.
 func NewOutForward(configServers []*ConfigServer) (*OutForward, error) {
 	loggers := make([]*fluent.Fluent, len(configServers))
+	weighted := false
 	for i, server := range configServers {
-		logger, err := fluent.New(fluent.Config{Server: server.Address()})
+		if server.Weight < 0 {
+			return nil, fmt.Errorf("Invalid Weight %d for server %s", server.Weight, server.Address())
+		}
+		if server.Weight > 0 {
+			weighted = true
+		}
+		switch server.Compress {
+		case "", fluent.CompressGzip:
+		default:
+			return nil, fmt.Errorf("Unsupported Compress %s for server %s", server.Compress, server.Address())
+		}
+		tlsConfig, err := server.ClientTLSConfig(server.Host)
+		if err != nil {
+			return nil, err
+		}
+		logger, err := fluent.New(fluent.Config{
+			Server:    server.Address(),
+			Compress:  server.Compress,
+			TLSConfig: tlsConfig,
+			Auth:      server.AuthConfig(),
+		})
 		if err != nil {
 			log.Println("[warning]", err)
 		} else {
@@ -34,15 +80,106 @@ func NewOutForward(configServers []*ConfigServer) (*OutForward, error) {
 		logger.Send([]byte{})
 	}
 	return &OutForward{
-		loggers: loggers,
-		sent:    0,
+		loggers:        loggers,
+		servers:        configServers,
+		weighted:       weighted,
+		currentWeights: make([]int, len(configServers)),
+		sents:          make([]int64, len(configServers)),
+		stopCh:         make(chan interface{}),
+		sent:           0,
 	}, nil
 }
 
+// EnableHeartbeat enables heartbeats to all servers.
+// Servers which were detected as down are skipped until recovered.
+func (f *OutForward) EnableHeartbeat(config *ConfigHeartbeat) error {
+	heartbeaters := make([]*Heartbeater, len(f.servers))
+	for i, server := range f.servers {
+		h, err := NewHeartbeater(config, server.Address())
+		if err != nil {
+			return err
+		}
+		heartbeaters[i] = h
+	}
+	f.heartbeaters = heartbeaters
+	return nil
+}
+
+// available returns false when the server is reconnecting or detected as down by heartbeats.
+func (f *OutForward) available(i int) bool {
+	if f.loggers[i].IsReconnecting() {
+		return false
+	}
+	return f.heartbeaters == nil || f.heartbeaters[i].Available()
+}
+
+// Policy returns the server selection policy.
+func (f *OutForward) Policy() string {
+	switch {
+	case f.weighted:
+		return PolicyWeighted
+	case f.RoundRobin:
+		return PolicyRoundRobin
+	default:
+		return PolicyPrimary
+	}
+}
+
+func (f *OutForward) weight(i int) int {
+	if f.servers[i].Weight > 0 {
+		return f.servers[i].Weight
+	}
+	return DefaultServerWeight
+}
+
+// selectServers returns indexes of servers in the order to try.
+// Standby servers follow all active servers.
+func (f *OutForward) selectServers() []int {
+	active := make([]int, 0, len(f.servers))
+	standby := make([]int, 0)
+	for i, server := range f.servers {
+		if server.Standby {
+			standby = append(standby, i)
+		} else {
+			active = append(active, i)
+		}
+	}
+	if f.Policy() != PolicyPrimary {
+		if n := f.nextWeighted(active); n > 0 {
+			// move the selected server to the head
+			active[0], active[n] = active[n], active[0]
+		}
+	}
+	return append(active, standby...)
+}
+
+// nextWeighted selects a healthy server by smooth weighted round robin, and returns its position in indexes.
+func (f *OutForward) nextWeighted(indexes []int) int {
+	total := 0
+	selected := -1
+	for n, i := range indexes {
+		if !f.available(i) {
+			continue
+		}
+		f.currentWeights[i] += f.weight(i)
+		total += f.weight(i)
+		if selected < 0 || f.currentWeights[i] > f.currentWeights[indexes[selected]] {
+			selected = n
+		}
+	}
+	if selected >= 0 {
+		f.currentWeights[indexes[selected]] -= total
+	}
+	return selected
+}
+
 func (f *OutForward) Run(c *Context) {
 	c.OutputProcess.Add(1)
 	defer c.OutputProcess.Done()
-	f.messageCh = c.MessageCh
+	f.messageCh = f.Input
+	if f.messageCh == nil {
+		f.messageCh = c.MessageCh
+	}
 	f.monitorCh = c.MonitorCh
 
 	c.StartProcess.Done()
@@ -50,9 +187,23 @@ func (f *OutForward) Run(c *Context) {
 	for i, _ := range f.loggers {
 		go f.checkServerHealth(i)
 	}
+	for _, h := range f.heartbeaters {
+		go h.Run(f.stopCh)
+	}
+	if f.Buffer == nil {
+		f.recieve(f.send)
+		f.shutdown()
+		log.Println("[info] shutdown out_forward", f.Name)
+		return
+	}
 
+	go func() {
+		f.recieve(f.Buffer.Push)
+		f.Buffer.Close()
+	}()
+	go f.reportBufferStat()
 	for {
-		err := f.outForwardRecieve()
+		err := f.outForwardBuffered()
 		if err != nil {
 			if _, ok := err.(Signal); ok {
 				log.Println("[info]", err)
@@ -64,57 +215,153 @@ func (f *OutForward) Run(c *Context) {
 	}
 }
 
-func (f *OutForward) outForwardRecieve() error {
-	recordSet, ok := <-f.messageCh
-	if !ok {
-		for _, logger := range f.loggers {
-			logger.Shutdown()
+// recieve ... recieve FluentRecordSet from channel, and emit packed entries until the channel is closed.
+// When FlushInterval > 0, entries are coalesced per tag.
+func (f *OutForward) recieve(emit func(tag string, entries []byte, messages int) error) {
+	var batcher *Batcher
+	var tick <-chan time.Time
+	if f.FlushInterval > 0 {
+		batcher = NewBatcher(f.FlushInterval, f.ChunkMaxRecords, f.ChunkMaxBytes)
+		interval := f.FlushInterval / 10
+		if interval < minFlushCheckInterval {
+			interval = minFlushCheckInterval
 		}
-		return Signal{"shutdown out_forward"}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
-	first := true
-	packed, err := recordSet.PackAsPackedForward()
+	flush := func(batches []*Batch) {
+		for _, batch := range batches {
+			f.monitorCh <- &SentStat{
+				Tag:          batch.Tag,
+				FlushReasons: map[string]int64{batch.Reason: 1},
+			}
+			if err := emit(batch.Tag, batch.Entries, batch.Messages); err != nil {
+				log.Printf("[error] failed to emit %d messages tag:%s %s", batch.Messages, batch.Tag, err)
+			}
+		}
+	}
+	for {
+		select {
+		case recordSet, ok := <-f.messageCh:
+			if !ok {
+				if batcher != nil {
+					flush(batcher.FlushAll())
+				}
+				return
+			}
+			if len(recordSet.Records) == 0 {
+				// e.g. only a CSV header line was read
+				continue
+			}
+			f.monitorCh <- &OutputStat{Name: f.Name, Records: int64(len(recordSet.Records))}
+			entries, err := recordSet.PackEntries()
+			if err != nil {
+				log.Println("[error]", err)
+				continue
+			}
+			if batcher == nil {
+				if err := emit(recordSet.Tag, entries, len(recordSet.Records)); err != nil {
+					log.Printf("[error] failed to emit %d messages tag:%s %s", len(recordSet.Records), recordSet.Tag, err)
+				}
+				continue
+			}
+			flush(batcher.Add(recordSet.Tag, entries, len(recordSet.Records)))
+		case now := <-tick:
+			flush(batcher.Expired(now))
+		}
+	}
+}
+
+// outForwardBuffered ... send the oldest chunk in the buffer, and remove it after sent.
+func (f *OutForward) outForwardBuffered() error {
+	chunk, err := f.Buffer.Peek()
 	if err != nil {
 		return err
 	}
-	nLoggers := int64(len(f.loggers))
+	if chunk == nil {
+		f.shutdown()
+		return Signal{"shutdown out_forward"}
+	}
+	if err := f.send(chunk.Tag, chunk.Entries, chunk.Messages); err != nil {
+		return err
+	}
+	f.Buffer.Remove(chunk)
+	return nil
+}
+
+func (f *OutForward) shutdown() {
+	close(f.stopCh)
+	for _, logger := range f.loggers {
+		logger.Shutdown()
+	}
+}
+
+// send ... send packed entries to any logger until success.
+func (f *OutForward) send(tag string, entries []byte, messages int) error {
+	first := true
+	var chunk string
+	if f.RequireAckResponse {
+		chunk = fluent.NewChunkID()
+	}
+	// packed messages for each compression
+	packedMessages := make(map[string][]byte, 1)
 	for {
-		var loggers []*fluent.Fluent
-		if f.RoundRobin {
-			index := f.sent % nLoggers
-			loggers = f.loggers[index:nLoggers]
-			loggers = append(loggers, f.loggers[0:index]...)
-		} else {
-			loggers = f.loggers
-		}
 	LOGGER:
-		for _, logger := range loggers {
-			if logger.IsReconnecting() {
+		for _, i := range f.selectServers() {
+			logger := f.loggers[i]
+			if !f.available(i) {
 				continue LOGGER
 			}
+			packed, ok := packedMessages[logger.Compress]
+			if !ok {
+				var err error
+				option := newForwardOption(chunk, logger.Compress, messages)
+				packed, err = fluent.PackPackedForward(tag, entries, option)
+				if err != nil {
+					return err
+				}
+				packedMessages[logger.Compress] = packed
+			}
 			err := logger.Send(packed)
 			if err != nil {
 				log.Println("[error]", err)
 				continue LOGGER
 			}
+			if chunk != "" {
+				timeout := f.AckResponseTimeout
+				if timeout == 0 {
+					timeout = DefaultAckResponseTimeout
+				}
+				if err := logger.ReceiveAck(chunk, timeout); err != nil {
+					log.Println("[error] ack response from", logger.Server, "failed.", err, "resending chunk", chunk)
+					continue LOGGER
+				}
+			}
 			f.monitorCh <- &SentStat{
-				Tag:      recordSet.Tag,
-				Messages: int64(len(recordSet.Records)),
+				Tag:      tag,
+				Messages: int64(messages),
 				Bytes:    int64(len(packed)),
+				RawBytes: int64(len(entries)),
 				Sents:    1,
 			}
-			f.sent++
+			atomic.AddInt64(&f.sents[i], 1)
+			atomic.AddInt64(&f.sent, 1)
 			if logger.Sent%maxKeepAliveSentCount == 0 {
 				logger.RefreshConnection()
 			}
 			return nil // success
 		}
 		// all loggers seems down...
+		if f.Buffer != nil && f.Buffer.Closed() {
+			f.shutdown()
+			return Signal{"shutdown out_forward. unsent chunks are left in the buffer"}
+		}
 		if first {
 			log.Printf(
 				"[warning] All servers are unavailable. pending %d messages tag:%s",
-				len(recordSet.Records),
-				recordSet.Tag,
+				messages,
+				tag,
 			)
 			first = false
 		}
@@ -122,14 +369,46 @@ func (f *OutForward) outForwardRecieve() error {
 	}
 }
 
+func newForwardOption(chunk string, compress string, size int) *fluent.ForwardOption {
+	if chunk == "" && compress == "" {
+		// compatible with fluentd <= 0.12
+		return nil
+	}
+	return &fluent.ForwardOption{
+		Chunk:      chunk,
+		Size:       size,
+		Compressed: compress,
+	}
+}
+
+func (f *OutForward) reportBufferStat() {
+	c := time.Tick(serverHealthCheckInterval)
+	for _ = range c {
+		stat := f.Buffer.Stat()
+		stat.Output = f.Name
+		f.monitorCh <- stat
+	}
+}
+
 func (f *OutForward) checkServerHealth(i int) {
 	c := time.Tick(serverHealthCheckInterval)
 	for _ = range c {
+		sent := atomic.LoadInt64(&f.sents[i])
+		var share float64
+		if total := atomic.LoadInt64(&f.sent); total > 0 {
+			share = float64(sent) / float64(total)
+		}
 		f.monitorCh <- &ServerStat{
 			Index:   i,
+			Output:  f.Name,
 			Address: f.loggers[i].Server,
-			Alive:   f.loggers[i].Alive(),
+			Alive:   f.loggers[i].Alive() && f.available(i),
 			Error:   f.loggers[i].LastErrorString(),
+			Policy:  f.Policy(),
+			Weight:  f.weight(i),
+			Standby: f.servers[i].Standby,
+			Sent:    sent,
+			Share:   share,
 		}
 	}
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/fujiwara/fluent-agent-hydra/fluent"
 	"github.com/fujiwara/fluent-agent-hydra/hydra"
+	"github.com/ugorji/go/codec"
 )
 
 var (
@@ -197,3 +198,166 @@ func handleConn(conn net.Conn, t *testing.T, counter *int64) {
 		}
 	}
 }
+
+func TestForwardAckResponse(t *testing.T) {
+	log.Println("---- TestForwardAckResponse ----")
+	primaryCounter := int64(0)
+	secondaryCounter := int64(0)
+
+	// primary server never returns ack
+	primaryAddr, primaryCloser := runMockAckServer(t, false, &primaryCounter)
+	secondaryAddr, secondaryCloser := runMockAckServer(t, true, &secondaryCounter)
+	configServers := []*hydra.ConfigServer{
+		newConfigServer(primaryAddr),
+		newConfigServer(secondaryAddr),
+	}
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward(configServers)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	outForward.RequireAckResponse = true
+	outForward.AckResponseTimeout = 1 * time.Second
+	c.RunProcess(outForward)
+
+	recordSet := prepareRecordSet()
+	c.MessageCh <- recordSet
+	sleep(3)
+
+	if n := atomic.LoadInt64(&primaryCounter); n != int64(len(TestMessageLines)) {
+		t.Error("primary server must recieve messages. sent", len(TestMessageLines), "recieved", n)
+	}
+	if n := atomic.LoadInt64(&secondaryCounter); n != int64(len(TestMessageLines)) {
+		t.Error("chunk must be resent to secondary. sent", len(TestMessageLines), "recieved", n)
+	}
+	c.Shutdown()
+	close(primaryCloser)
+	close(secondaryCloser)
+	sleep(1)
+}
+
+func runMockAckServer(t *testing.T, ack bool, counter *int64) (string, chan bool) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan bool)
+	go func() {
+		<-ch
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleAckConn(conn, ack, counter)
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func handleAckConn(conn net.Conn, ack bool, counter *int64) {
+	defer conn.Close()
+	var mh codec.MsgpackHandle
+	for {
+		v := []interface{}{}
+		if err := codec.NewDecoder(conn, &mh).Decode(&v); err != nil {
+			return
+		}
+		if len(v) != 3 {
+			log.Println("[error][mockServer] option is not found", v)
+			return
+		}
+		option := v[2].(map[interface{}]interface{})
+		if size, ok := option["size"]; ok {
+			atomic.AddInt64(counter, size.(int64))
+		}
+		if !ack {
+			continue
+		}
+		chunk := string(option["chunk"].([]byte))
+		codec.NewEncoder(conn, &mh).Encode(map[string]string{"ack": chunk})
+	}
+}
+
+func TestForwardCompressGzip(t *testing.T) {
+	log.Println("---- TestForwardCompressGzip ----")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	type received struct {
+		option     *fluent.ForwardOption
+		recordSets []fluent.FluentRecordSet
+		err        error
+	}
+	receivedCh := make(chan received, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			receivedCh <- received{err: err}
+			return
+		}
+		defer conn.Close()
+		recordSets, option, err := fluent.DecodeEntriesWithOption(conn)
+		receivedCh <- received{option, recordSets, err}
+	}()
+
+	configServer := newConfigServer(l.Addr().String())
+	configServer.Compress = "gzip"
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{configServer})
+	if err != nil {
+		t.Error(err)
+	}
+	c.RunProcess(outForward)
+
+	// a compressible payload
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = strings.Repeat("message", 10)
+	}
+	c.MessageCh <- hydra.NewFluentRecordSet(TestTag, TestFieldName, hydra.FormatNone, nil, nil, []byte(strings.Join(lines, "\n")))
+
+	select {
+	case r := <-receivedCh:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.option == nil || r.option.Compressed != fluent.CompressGzip {
+			t.Errorf("unexpected option %#v", r.option)
+		}
+		if len(r.recordSets) != 1 || len(r.recordSets[0].Records) != len(lines) {
+			t.Fatalf("unexpected record sets %#v", r.recordSets)
+		}
+		for _, record := range r.recordSets[0].Records {
+			if message, _ := record.GetData(TestFieldName); message != lines[0] {
+				t.Errorf("unexpected record %#v", record)
+			}
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("entries were not received")
+	}
+	var stat *hydra.SentStat
+	for stat == nil {
+		if s, ok := (<-c.MonitorCh).(*hydra.SentStat); ok {
+			stat = s
+		}
+	}
+	if stat.Bytes == 0 || stat.Bytes >= stat.RawBytes {
+		t.Errorf("compressed bytes must be less than raw bytes %#v", stat)
+	}
+	c.Shutdown()
+}
+
+func TestForwardInvalidCompress(t *testing.T) {
+	configServer := newConfigServer("127.0.0.1:24224")
+	configServer.Compress = "lz4"
+	if _, err := hydra.NewOutForward([]*hydra.ConfigServer{configServer}); err == nil {
+		t.Error("unsupported Compress must be an error")
+	}
+}
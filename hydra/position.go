@@ -0,0 +1,225 @@
+package hydra
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PositionFileFlushInterval is the minimum interval of writing a position file.
+var PositionFileFlushInterval = time.Second
+
+// PositionFile records read positions of tailed files (compatible with fluentd's pos_file format).
+// Updates are written at most once per PositionFileFlushInterval.
+type PositionFile struct {
+	path      string
+	entries   map[string]*Position
+	persisted map[string]int64 // offsets written to disk
+	dirty     bool
+	lastSaved time.Time
+	timer     *time.Timer
+	mu        sync.Mutex
+}
+
+type Position struct {
+	Path   string
+	Offset int64
+	Inode  uint64
+}
+
+var (
+	positionFiles   = make(map[string]*PositionFile)
+	positionFilesMu sync.Mutex
+)
+
+// OpenPositionFile loads a position file. The same *PositionFile is returned for the same path.
+func OpenPositionFile(path string) (*PositionFile, error) {
+	path, err := Rel2Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	positionFilesMu.Lock()
+	defer positionFilesMu.Unlock()
+	if pf, ok := positionFiles[path]; ok {
+		return pf, nil
+	}
+	pf := &PositionFile{
+		path:      path,
+		entries:   make(map[string]*Position),
+		persisted: make(map[string]int64),
+	}
+	if err := pf.load(); err != nil {
+		return nil, err
+	}
+	positionFiles[path] = pf
+	log.Println("[info] Position file", path, "loaded.", len(pf.entries), "entries")
+	return pf, nil
+}
+
+func (pf *PositionFile) load() error {
+	f, err := os.Open(pf.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) != 3 {
+			continue
+		}
+		offset, err := strconv.ParseInt(cols[1], 16, 64)
+		if err != nil {
+			log.Println("[warning] invalid position", cols[1], "in", pf.path)
+			continue
+		}
+		inode, err := strconv.ParseUint(cols[2], 16, 64)
+		if err != nil {
+			log.Println("[warning] invalid inode", cols[2], "in", pf.path)
+			continue
+		}
+		pf.entries[cols[0]] = &Position{
+			Path:   cols[0],
+			Offset: offset,
+			Inode:  inode,
+		}
+		pf.persisted[cols[0]] = offset
+	}
+	return scanner.Err()
+}
+
+// Get returns a recorded position of the file.
+func (pf *PositionFile) Get(path string) (Position, bool) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if p, ok := pf.entries[path]; ok {
+		return *p, true
+	}
+	return Position{}, false
+}
+
+// Persisted returns the position of the file which was written to disk.
+func (pf *PositionFile) Persisted(path string) (int64, bool) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	offset, ok := pf.persisted[path]
+	return offset, ok
+}
+
+// Update records a position of the file. Entries are written to disk immediately,
+// or after PositionFileFlushInterval elapsed since the last write.
+func (pf *PositionFile) Update(path string, inode uint64, offset int64) error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if p, ok := pf.entries[path]; ok {
+		if p.Inode == inode && p.Offset == offset {
+			return nil
+		}
+		p.Inode = inode
+		p.Offset = offset
+	} else {
+		pf.entries[path] = &Position{
+			Path:   path,
+			Offset: offset,
+			Inode:  inode,
+		}
+	}
+	pf.dirty = true
+	wait := PositionFileFlushInterval - time.Since(pf.lastSaved)
+	if wait <= 0 {
+		return pf.save()
+	}
+	if pf.timer == nil {
+		pf.timer = time.AfterFunc(wait, func() {
+			if err := pf.Flush(); err != nil {
+				log.Println("[warning] couldn't save position file", pf.path, err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush writes entries which are not written yet.
+func (pf *PositionFile) Flush() error {
+	if pf == nil {
+		return nil
+	}
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if !pf.dirty {
+		return nil
+	}
+	return pf.save()
+}
+
+// Remove deletes a position of the file.
+func (pf *PositionFile) Remove(path string) error {
+	if pf == nil {
+		return nil
+	}
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if _, ok := pf.entries[path]; !ok {
+		return nil
+	}
+	delete(pf.entries, path)
+	return pf.save()
+}
+
+// save writes all entries to disk. must be called with lock.
+func (pf *PositionFile) save() error {
+	if pf.timer != nil {
+		pf.timer.Stop()
+		pf.timer = nil
+	}
+	pf.lastSaved = time.Now()
+	paths := make([]string, 0, len(pf.entries))
+	for path := range pf.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(pf.path), filepath.Base(pf.path)+".")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, path := range paths {
+		p := pf.entries[path]
+		fmt.Fprintf(w, "%s\t%016x\t%016x\n", p.Path, p.Offset, p.Inode)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// replace atomically
+	if err := os.Rename(tmp.Name(), pf.path); err != nil {
+		return err
+	}
+	pf.persisted = make(map[string]int64, len(pf.entries))
+	for path, p := range pf.entries {
+		pf.persisted[path] = p.Offset
+	}
+	pf.dirty = false
+	return nil
+}
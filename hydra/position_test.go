@@ -0,0 +1,130 @@
+package hydra_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestPositionFile(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "pos")
+
+	pf, err := hydra.OpenPositionFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pf.Get("/tmp/foo.log"); ok {
+		t.Error("position must not be recorded")
+	}
+	if err := pf.Update("/tmp/foo.log", 12345, 100); err != nil {
+		t.Error(err)
+	}
+	if err := pf.Update("/tmp/bar.log", 67890, 200); err != nil {
+		t.Error(err)
+	}
+	if err := pf.Update("/tmp/foo.log", 12345, 150); err != nil {
+		t.Error(err)
+	}
+	// updates within PositionFileFlushInterval are not written yet
+	b, _ := ioutil.ReadFile(path)
+	if expected := "/tmp/foo.log\t0000000000000064\t0000000000003039\n"; string(b) != expected {
+		t.Errorf("unexpected position file %q", string(b))
+	}
+	if offset, _ := pf.Persisted("/tmp/foo.log"); offset != 100 {
+		t.Errorf("unexpected persisted position %d", offset)
+	}
+	if _, ok := pf.Persisted("/tmp/bar.log"); ok {
+		t.Error("position must not be persisted yet")
+	}
+	if err := pf.Flush(); err != nil {
+		t.Error(err)
+	}
+	if offset, _ := pf.Persisted("/tmp/foo.log"); offset != 150 {
+		t.Errorf("unexpected persisted position %d", offset)
+	}
+	b, _ = ioutil.ReadFile(path)
+	expected := "/tmp/bar.log\t00000000000000c8\t0000000000010932\n" +
+		"/tmp/foo.log\t0000000000000096\t0000000000003039\n"
+	if string(b) != expected {
+		t.Errorf("unexpected position file %q", string(b))
+	}
+	if err := pf.Remove("/tmp/bar.log"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := pf.Get("/tmp/bar.log"); ok {
+		t.Error("position must be removed")
+	}
+	if p, ok := pf.Get("/tmp/foo.log"); !ok || p.Offset != 150 || p.Inode != 12345 {
+		t.Errorf("unexpected position %#v", p)
+	}
+}
+
+func TestTrailResumePosition(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	file, _ := ioutil.TempFile(tmpdir, "logfile.")
+	configLogfile := &hydra.ConfigLogfile{
+		Tag:       "test",
+		File:      file.Name(),
+		FieldName: "message",
+		PosFile:   filepath.Join(tmpdir, "pos"),
+	}
+
+	run := func(lines []string) []string {
+		c := hydra.NewContext()
+		watcher, _ := hydra.NewWatcher()
+		inTail, err := hydra.NewInTail(configLogfile, watcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.RunProcess(inTail)
+		c.RunProcess(watcher)
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			for _, line := range lines {
+				file.WriteString(line + "\n")
+			}
+		}()
+		recieved := make([]string, 0)
+	RECEIVE:
+		for {
+			select {
+			case rs := <-c.MessageCh:
+				for _, record := range rs.Records {
+					message, _ := record.GetData("message")
+					recieved = append(recieved, string(message.([]byte)))
+				}
+			case <-time.After(2 * time.Second):
+				break RECEIVE
+			}
+		}
+		c.Shutdown()
+		return recieved
+	}
+
+	if got := run([]string{"line1", "line2"}); strings.Join(got, ",") != "line1,line2" {
+		t.Errorf("unexpected lines %v", got)
+	}
+	// written while the agent is stopped
+	file.WriteString("line3\nline4\n")
+
+	if got := run([]string{"line5"}); strings.Join(got, ",") != "line3,line4,line5" {
+		t.Errorf("unexpected lines after resume %v", got)
+	}
+
+	// truncated while the agent is stopped
+	file.Truncate(0)
+	file.Seek(0, os.SEEK_SET)
+	file.WriteString("new1\n")
+	if got := run([]string{"new2"}); strings.Join(got, ",") != "new1,new2" {
+		t.Errorf("unexpected lines after truncate %v", got)
+	}
+	file.Close()
+}
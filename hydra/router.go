@@ -0,0 +1,148 @@
+package hydra
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+)
+
+const DefaultOutputName = "default"
+
+// Router sends record sets to the output of the first Match which has the tag matching.
+// Record sets which match no Match are sent to the default output, or dropped when it does not exist.
+// Matches with Copy send record sets to their outputs, and continue routing.
+// Each output has its own queue, so an output which is down does not block others.
+type Router struct {
+	matches   []*ConfigMatch
+	outputs   map[string]chan *fluent.FluentRecordSet
+	queues    map[string]*routerQueue
+	monitorCh chan Stat
+}
+
+// routerQueue holds record sets until the output reads them.
+// The oldest record sets are disposed when it exceeds DefaultMaxBufferMessages.
+type routerQueue struct {
+	queue  *MessageQueue
+	notify chan interface{}
+}
+
+func NewRouter(matches []*ConfigMatch, outputs []string) (*Router, error) {
+	r := &Router{
+		matches: matches,
+		outputs: make(map[string]chan *fluent.FluentRecordSet, len(outputs)),
+		queues:  make(map[string]*routerQueue, len(outputs)),
+	}
+	for _, name := range outputs {
+		r.outputs[name] = make(chan *fluent.FluentRecordSet, MessageChannelBufferLen)
+		r.queues[name] = &routerQueue{
+			queue:  NewMessageQueue(DefaultMaxBufferMessages),
+			notify: make(chan interface{}, 1),
+		}
+	}
+	for _, m := range matches {
+		if _, ok := r.outputs[m.Output]; !ok {
+			return nil, fmt.Errorf("Output %s for Match %s is not defined", m.Output, m.Pattern)
+		}
+	}
+	return r, nil
+}
+
+// Output returns the channel of the output to be read by the output process.
+func (r *Router) Output(name string) chan *fluent.FluentRecordSet {
+	return r.outputs[name]
+}
+
+// Route returns names of the outputs for the tag.
+func (r *Router) Route(tag string) []string {
+	outputs := make([]string, 0, 1)
+	add := func(name string) {
+		for _, o := range outputs {
+			if o == name {
+				return
+			}
+		}
+		outputs = append(outputs, name)
+	}
+	for _, m := range r.matches {
+		if !m.Pattern.Match(tag) {
+			continue
+		}
+		add(m.Output)
+		if !m.Copy {
+			return outputs
+		}
+	}
+	if _, ok := r.outputs[DefaultOutputName]; ok {
+		add(DefaultOutputName)
+	}
+	return outputs
+}
+
+func (r *Router) Run(c *Context) {
+	c.OutputProcess.Add(1)
+	defer c.OutputProcess.Done()
+	r.monitorCh = c.MonitorCh
+
+	c.StartProcess.Done()
+
+	done := make(chan interface{})
+	var wg sync.WaitGroup
+	for name := range r.outputs {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			r.feed(name, done)
+		}(name)
+	}
+	for rs := range c.MessageCh {
+		outputs := r.Route(rs.Tag)
+		if len(outputs) == 0 {
+			r.monitorCh <- &RouteStat{Tag: rs.Tag, Dropped: int64(len(rs.Records))}
+			continue
+		}
+		// record sets are not modified by outputs, so copies share them
+		for _, output := range outputs {
+			q := r.queues[output]
+			disposed := q.queue.Enqueue(rs)
+			select {
+			case q.notify <- nil:
+			default:
+			}
+			if disposed > 0 {
+				log.Printf("[warning] output %s is too slow. disposed %d records", output, disposed)
+			}
+			r.monitorCh <- &RouteStat{Tag: rs.Tag, Output: output, Records: int64(len(rs.Records)), Disposed: disposed}
+		}
+	}
+	close(done)
+	wg.Wait()
+	log.Println("[info] shutdown router")
+}
+
+// feed sends queued record sets to the output, and closes the output channel after done is closed and the queue is drained.
+func (r *Router) feed(name string, done chan interface{}) {
+	q := r.queues[name]
+	ch := r.outputs[name]
+	for {
+		if rs, ok := q.queue.Dequeue(); ok {
+			ch <- rs
+			continue
+		}
+		select {
+		case <-q.notify:
+		case <-done:
+			// nothing is enqueued after done is closed
+			for {
+				rs, ok := q.queue.Dequeue()
+				if !ok {
+					break
+				}
+				ch <- rs
+			}
+			close(ch)
+			return
+		}
+	}
+}
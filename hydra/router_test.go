@@ -0,0 +1,198 @@
+package hydra_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func newConfigMatch(t *testing.T, pattern, output string) *hydra.ConfigMatch {
+	tp, err := hydra.NewTagPattern(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &hydra.ConfigMatch{Pattern: tp, Output: output}
+}
+
+func TestRouterRoute(t *testing.T) {
+	matches := []*hydra.ConfigMatch{
+		newConfigMatch(t, "audit.**", "audit"),
+		newConfigMatch(t, "app.{4xx,5xx}.* access.*", "access"),
+		newConfigMatch(t, "audit.debug", "access"), // never used
+	}
+	router, err := hydra.NewRouter(matches, []string{"default", "audit", "access"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, output := range map[string]string{
+		"audit":           "audit",
+		"audit.debug":     "audit",
+		"app.5xx.GET":     "access",
+		"app.2xx.GET":     "default",
+		"access.nginx":    "access",
+		"access.nginx.v2": "default",
+	} {
+		if o := router.Route(tag); len(o) != 1 || o[0] != output {
+			t.Errorf("tag %s expected routed to %s got %v", tag, output, o)
+		}
+	}
+
+	router, _ = hydra.NewRouter(matches[0:1], []string{"audit"})
+	if o := router.Route("app"); len(o) != 0 {
+		t.Errorf("tag app must not be routed without the default output. got %v", o)
+	}
+
+	if _, err := hydra.NewRouter(matches, []string{"default", "audit"}); err == nil {
+		t.Error("undefined output must be an error")
+	}
+}
+
+func TestRouterCopy(t *testing.T) {
+	copyAll := newConfigMatch(t, "**", "archive")
+	copyAll.Copy = true
+	copyAudit := newConfigMatch(t, "audit.**", "new")
+	copyAudit.Copy = true
+	matches := []*hydra.ConfigMatch{
+		copyAll,
+		copyAudit,
+		newConfigMatch(t, "audit.**", "audit"),
+		newConfigMatch(t, "debug.**", "archive"),
+	}
+	router, err := hydra.NewRouter(matches, []string{"default", "audit", "archive", "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, outputs := range map[string][]string{
+		"app":         {"archive", "default"},
+		"audit.login": {"archive", "new", "audit"},
+		"debug.sql":   {"archive"},
+	} {
+		o := router.Route(tag)
+		if len(o) != len(outputs) {
+			t.Errorf("tag %s expected routed to %v got %v", tag, outputs, o)
+			continue
+		}
+		for i := range outputs {
+			if o[i] != outputs[i] {
+				t.Errorf("tag %s expected routed to %v got %v", tag, outputs, o)
+			}
+		}
+	}
+}
+
+func TestRouterForward(t *testing.T) {
+	var defaultCounter, auditCounter int64
+	defaultAddr, defaultCloser := runMockServer(t, "", &defaultCounter)
+	auditAddr, auditCloser := runMockServer(t, "", &auditCounter)
+	defer close(defaultCloser)
+	defer close(auditCloser)
+
+	c := hydra.NewContext()
+	router, err := hydra.NewRouter(
+		[]*hydra.ConfigMatch{newConfigMatch(t, "audit.**", "audit")},
+		[]string{"default", "audit"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for output, addr := range map[string]string{"default": defaultAddr, "audit": auditAddr} {
+		outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{newConfigServer(addr)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outForward.Name = output
+		outForward.Input = router.Output(output)
+		c.RunProcess(outForward)
+	}
+	c.RunProcess(router)
+	c.StartProcess.Wait()
+
+	c.MessageCh <- prepareRecordSet()
+	audit := prepareRecordSet()
+	audit.Tag = "audit.login"
+	c.MessageCh <- audit
+	c.MessageCh <- audit
+	c.MessageCh <- &fluent.FluentRecordSet{Tag: "audit.empty"}
+	sleep(2)
+	c.Shutdown()
+
+	if n := atomic.LoadInt64(&defaultCounter); n != int64(len(TestMessageLines)) {
+		t.Errorf("default output expected %d messages got %d", len(TestMessageLines), n)
+	}
+	if n := atomic.LoadInt64(&auditCounter); n != int64(len(TestMessageLines)*2) {
+		t.Errorf("audit output expected %d messages got %d", len(TestMessageLines)*2, n)
+	}
+
+	stats := &hydra.Stats{}
+	for len(c.MonitorCh) > 0 {
+		switch s := (<-c.MonitorCh).(type) {
+		case *hydra.RouteStat, *hydra.OutputStat:
+			s.ApplyTo(stats)
+		}
+	}
+	if s := stats.Routes["audit.login"]; s == nil || s.Outputs["audit"] != int64(len(TestMessageLines)*2) {
+		t.Errorf("unexpected route stat %#v", s)
+	}
+	if s := stats.Routes[TestTag]; s == nil || s.Outputs["default"] != int64(len(TestMessageLines)) {
+		t.Errorf("unexpected route stat %#v", s)
+	}
+	if s := stats.Outputs["audit"]; s == nil || s.Records != int64(len(TestMessageLines)*2) {
+		t.Errorf("unexpected output stat %#v", s)
+	}
+}
+
+func TestRouterOutputDown(t *testing.T) {
+	c := hydra.NewContext()
+	copied := newConfigMatch(t, "app.**", "audit")
+	copied.Copy = true
+	router, err := hydra.NewRouter(
+		[]*hydra.ConfigMatch{copied},
+		[]string{"default", "audit"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(router)
+	c.StartProcess.Wait()
+
+	// the audit output is down and does not read any record sets
+	n := 10
+	go func() {
+		for i := 0; i < n; i++ {
+			rs := prepareRecordSet()
+			rs.Tag = "app.access"
+			c.MessageCh <- rs
+		}
+	}()
+	received := 0
+RECEIVE:
+	for received < n {
+		select {
+		case <-router.Output("default"):
+			received++
+		case <-time.After(time.Second):
+			break RECEIVE
+		}
+	}
+	if received != n {
+		t.Errorf("default output expected %d record sets got %d", n, received)
+	}
+
+	// the audit output recovers, and reads queued record sets
+	audit := 0
+	done := make(chan interface{})
+	go func() {
+		for _ = range router.Output("audit") {
+			audit++
+		}
+		close(done)
+	}()
+	c.Shutdown()
+	<-done
+	if audit != n {
+		t.Errorf("audit output expected %d record sets got %d", n, audit)
+	}
+}
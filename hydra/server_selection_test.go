@@ -0,0 +1,88 @@
+package hydra_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestForwardWeighted(t *testing.T) {
+	rc1, inForward1 := runAuthReceiver(t, hydra.ConfigAuth{})
+	rc2, inForward2 := runAuthReceiver(t, hydra.ConfigAuth{})
+
+	server1 := newConfigServer(inForward1.Addr.String())
+	server1.Weight = 3
+	server2 := newConfigServer(inForward2.Addr.String())
+	server2.Weight = 1
+
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{server1, server2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := outForward.Policy(); p != hydra.PolicyWeighted {
+		t.Errorf("unexpected policy %s", p)
+	}
+	c.RunProcess(outForward)
+	for i := 0; i < 8; i++ {
+		c.MessageCh <- prepareRecordSet()
+	}
+
+	if n := countMessages(rc1.MessageCh, time.Second); n != len(TestMessageLines)*6 {
+		t.Errorf("arrived messages to server1 %d expected %d", n, len(TestMessageLines)*6)
+	}
+	if n := countMessages(rc2.MessageCh, 100*time.Millisecond); n != len(TestMessageLines)*2 {
+		t.Errorf("arrived messages to server2 %d expected %d", n, len(TestMessageLines)*2)
+	}
+	c.Shutdown()
+}
+
+func TestForwardStandby(t *testing.T) {
+	rc1, inForward1 := runAuthReceiver(t, hydra.ConfigAuth{})
+	rc2, inForward2 := runAuthReceiver(t, hydra.ConfigAuth{})
+
+	// reserve an address which nobody listens
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	down := newConfigServer(l.Addr().String())
+	l.Close()
+
+	active := newConfigServer(inForward1.Addr.String())
+	standby := newConfigServer(inForward2.Addr.String())
+	standby.Standby = true
+
+	// the standby server is listed first, but receives nothing while an active server is healthy
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{standby, active})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outForward.RoundRobin = true
+	c.RunProcess(outForward)
+	for i := 0; i < 4; i++ {
+		c.MessageCh <- prepareRecordSet()
+	}
+	if n := countMessages(rc1.MessageCh, time.Second); n != len(TestMessageLines)*4 {
+		t.Errorf("arrived messages to active %d expected %d", n, len(TestMessageLines)*4)
+	}
+	if n := countMessages(rc2.MessageCh, 100*time.Millisecond); n != 0 {
+		t.Errorf("arrived messages to standby %d expected %d", n, 0)
+	}
+	c.Shutdown()
+
+	// all active servers are down
+	c = hydra.NewContext()
+	outForward, err = hydra.NewOutForward([]*hydra.ConfigServer{down, standby})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(outForward)
+	for i := 0; i < 4; i++ {
+		c.MessageCh <- prepareRecordSet()
+	}
+	if n := countMessages(rc2.MessageCh, time.Second); n != len(TestMessageLines)*4 {
+		t.Errorf("arrived messages to standby %d expected %d", n, len(TestMessageLines)*4)
+	}
+	c.Shutdown()
+}
@@ -0,0 +1,265 @@
+package hydra
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	SyslogRFC3164  = "rfc3164"
+	SyslogRFC5424  = "rfc5424"
+	syslogNilValue = "-"
+	rfc3164TimeLen = len(time.Stamp)
+)
+
+var (
+	SyslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	SyslogSeverities = []string{
+		"emerg", "alert", "crit", "err", "warn", "notice", "info", "debug",
+	}
+)
+
+// SyslogMessage is a parsed syslog message. Empty fields are nil values of the message.
+type SyslogMessage struct {
+	Priority       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+func (m *SyslogMessage) Facility() string {
+	if f := m.Priority / 8; f < len(SyslogFacilities) {
+		return SyslogFacilities[f]
+	}
+	return strconv.Itoa(m.Priority / 8)
+}
+
+func (m *SyslogMessage) Severity() string {
+	return SyslogSeverities[m.Priority%8]
+}
+
+// Record converts the message into a record. The message body is stored in the key.
+func (m *SyslogMessage) Record(key string) map[string]interface{} {
+	data := map[string]interface{}{
+		"pri":      m.Priority,
+		"facility": m.Facility(),
+		"severity": m.Severity(),
+		key:        m.Message,
+	}
+	for k, v := range map[string]string{
+		"hostname": m.Hostname,
+		"app_name": m.AppName,
+		"procid":   m.ProcID,
+		"msgid":    m.MsgID,
+	} {
+		if v != "" {
+			data[k] = v
+		}
+	}
+	if len(m.StructuredData) > 0 {
+		sd := make(map[string]interface{}, len(m.StructuredData))
+		for id, params := range m.StructuredData {
+			sd[id] = params
+		}
+		data["structured_data"] = sd
+	}
+	return data
+}
+
+// ParseSyslog parses a RFC3164 or RFC5424 message. format is "" (auto detection), SyslogRFC3164 or SyslogRFC5424.
+func ParseSyslog(line []byte, format string, now time.Time) (*SyslogMessage, error) {
+	line = bytes.TrimRight(line, "\r\n\x00")
+	if len(line) < 3 || line[0] != '<' {
+		return nil, errors.New("syslog priority not found")
+	}
+	end := bytes.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, errors.New("invalid syslog priority")
+	}
+	pri, err := strconv.Atoi(string(line[1:end]))
+	if err != nil || pri > 191 {
+		return nil, fmt.Errorf("invalid syslog priority %s", line[1:end])
+	}
+	m := &SyslogMessage{Priority: pri}
+	rest := line[end+1:]
+
+	isRFC5424 := len(rest) >= 2 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' '
+	switch format {
+	case "":
+	case SyslogRFC5424:
+		if !isRFC5424 {
+			return nil, errors.New("not a RFC5424 message")
+		}
+	case SyslogRFC3164:
+		isRFC5424 = false
+	default:
+		return nil, fmt.Errorf("unsupported syslog format %s", format)
+	}
+	if isRFC5424 {
+		err = m.parseRFC5424(rest[2:])
+	} else {
+		m.parseRFC3164(rest, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG" leniently.
+func (m *SyslogMessage) parseRFC3164(b []byte, now time.Time) {
+	m.Timestamp = now
+	if len(b) > rfc3164TimeLen && b[rfc3164TimeLen] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, string(b[0:rfc3164TimeLen]), now.Location()); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.AddDate(0, 0, 1)) {
+				// the message was sent in the last year
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Timestamp = t
+			b = b[rfc3164TimeLen+1:]
+			// HOSTNAME may be omitted ("Mmm dd hh:mm:ss TAG[PID]: MSG")
+			if host, rest, ok := nextField(b); ok && !isRFC3164Tag(host) {
+				m.Hostname = string(host)
+				b = rest
+			}
+		}
+	}
+	// TAG is terminated by ':' or '[', up to 32 characters
+	for i := 0; i < len(b) && i <= 32; i++ {
+		switch b[i] {
+		case ' ':
+			m.Message = string(b)
+			return
+		case '[':
+			end := bytes.IndexByte(b[i:], ']')
+			if end < 0 {
+				m.Message = string(b)
+				return
+			}
+			m.AppName = string(b[0:i])
+			m.ProcID = string(b[i+1 : i+end])
+			b = bytes.TrimPrefix(b[i+end+1:], []byte(":"))
+			m.Message = string(bytes.TrimPrefix(b, []byte(" ")))
+			return
+		case ':':
+			m.AppName = string(b[0:i])
+			m.Message = string(bytes.TrimPrefix(b[i+1:], []byte(" ")))
+			return
+		}
+	}
+	m.Message = string(b)
+}
+
+// isRFC3164Tag returns true if the field is TAG ("app:" or "app[123]:") instead of HOSTNAME.
+func isRFC3164Tag(field []byte) bool {
+	return bytes.HasSuffix(field, []byte(":")) || bytes.IndexByte(field, '[') >= 0
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]" after VERSION.
+func (m *SyslogMessage) parseRFC5424(b []byte) error {
+	headers := make([]string, 5)
+	for i := range headers {
+		field, rest, ok := nextField(b)
+		if !ok {
+			return errors.New("invalid RFC5424 header")
+		}
+		if s := string(field); s != syslogNilValue {
+			headers[i] = s
+		}
+		b = rest
+	}
+	if headers[0] == "" {
+		m.Timestamp = time.Now()
+	} else {
+		t, err := time.Parse(time.RFC3339Nano, headers[0])
+		if err != nil {
+			return err
+		}
+		m.Timestamp = t
+	}
+	m.Hostname, m.AppName, m.ProcID, m.MsgID = headers[1], headers[2], headers[3], headers[4]
+
+	if bytes.HasPrefix(b, []byte(syslogNilValue)) {
+		b = b[1:]
+	} else {
+		sd, rest, err := parseStructuredData(b)
+		if err != nil {
+			return err
+		}
+		m.StructuredData = sd
+		b = rest
+	}
+	b = bytes.TrimPrefix(b, []byte(" "))
+	// strip UTF-8 BOM
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	m.Message = string(b)
+	return nil
+}
+
+// parseStructuredData parses `[id key="value" ...][id ...]`.
+func parseStructuredData(b []byte) (map[string]map[string]string, []byte, error) {
+	sd := make(map[string]map[string]string)
+	for len(b) > 0 && b[0] == '[' {
+		b = b[1:]
+		n := bytes.IndexAny(b, " ]")
+		if n < 1 {
+			return nil, nil, errors.New("invalid structured data")
+		}
+		params := make(map[string]string)
+		sd[string(b[0:n])] = params
+		b = b[n:]
+		for len(b) > 0 && b[0] == ' ' {
+			b = b[1:]
+			eq := bytes.Index(b, []byte(`="`))
+			if eq < 1 {
+				return nil, nil, errors.New("invalid structured data param")
+			}
+			name := string(b[0:eq])
+			b = b[eq+2:]
+			var value []byte
+			closed := false
+			for i := 0; i < len(b); i++ {
+				if b[i] == '\\' && i+1 < len(b) && (b[i+1] == '"' || b[i+1] == '\\' || b[i+1] == ']') {
+					value = append(value, b[i+1])
+					i++
+					continue
+				}
+				if b[i] == '"' {
+					b = b[i+1:]
+					closed = true
+					break
+				}
+				value = append(value, b[i])
+			}
+			if !closed {
+				return nil, nil, errors.New("unterminated structured data param")
+			}
+			params[name] = string(value)
+		}
+		if len(b) == 0 || b[0] != ']' {
+			return nil, nil, errors.New("unterminated structured data")
+		}
+		b = b[1:]
+	}
+	return sd, b, nil
+}
+
+func nextField(b []byte) ([]byte, []byte, bool) {
+	n := bytes.IndexByte(b, ' ')
+	if n < 1 {
+		return nil, nil, false
+	}
+	return b[0:n], b[n+1:], true
+}
@@ -0,0 +1,110 @@
+package hydra_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+func TestParseSyslogRFC3164(t *testing.T) {
+	now := time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC)
+	m, err := hydra.ParseSyslog([]byte("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8\n"), "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Facility() != "auth" || m.Severity() != "crit" ||
+		m.Hostname != "mymachine" || m.AppName != "su" || m.ProcID != "123" ||
+		m.Message != "'su root' failed for lonvick on /dev/pts/8" {
+		t.Errorf("unexpected message %#v", m)
+	}
+	// Oct 11 is in the last year of now
+	if !m.Timestamp.Equal(time.Date(2015, 10, 11, 22, 14, 15, 0, time.UTC)) {
+		t.Errorf("unexpected timestamp %s", m.Timestamp)
+	}
+
+	m, err = hydra.ParseSyslog([]byte("<13>Jan  1 23:59:59 host cron: job started"), "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Facility() != "user" || m.Severity() != "notice" || m.AppName != "cron" || m.ProcID != "" ||
+		m.Message != "job started" || m.Timestamp.Year() != 2016 {
+		t.Errorf("unexpected message %#v", m)
+	}
+
+	// without hostname
+	for line, procID := range map[string]string{
+		"<13>Oct 11 22:14:15 app[1]: msg": "1",
+		"<13>Oct 11 22:14:15 app: msg":    "",
+	} {
+		m, err = hydra.ParseSyslog([]byte(line), "", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Hostname != "" || m.AppName != "app" || m.ProcID != procID || m.Message != "msg" {
+			t.Errorf("unexpected message %#v", m)
+		}
+	}
+
+	// without timestamp and hostname
+	m, err = hydra.ParseSyslog([]byte("<14>hello world"), "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Message != "hello world" || !m.Timestamp.Equal(now) {
+		t.Errorf("unexpected message %#v", m)
+	}
+}
+
+func TestParseSyslogRFC5424(t *testing.T) {
+	line := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high\"er"] An application event log entry...`
+	m, err := hydra.ParseSyslog([]byte(line), hydra.SyslogRFC5424, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Facility() != "local4" || m.Severity() != "notice" ||
+		m.Hostname != "mymachine.example.com" || m.AppName != "evntslog" || m.ProcID != "" || m.MsgID != "ID47" ||
+		m.Message != "An application event log entry..." {
+		t.Errorf("unexpected message %#v", m)
+	}
+	if !m.Timestamp.Equal(time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)) {
+		t.Errorf("unexpected timestamp %s", m.Timestamp)
+	}
+	if sd := m.StructuredData["exampleSDID@32473"]; sd["iut"] != "3" || sd["eventSource"] != "Application" || sd["eventID"] != "1011" {
+		t.Errorf("unexpected structured data %#v", m.StructuredData)
+	}
+	if sd := m.StructuredData["examplePriority@32473"]; sd["class"] != `high"er` {
+		t.Errorf("unexpected structured data %#v", m.StructuredData)
+	}
+
+	m, err = hydra.ParseSyslog([]byte(`<34>1 2003-10-11T22:14:15Z mymachine su 77 - - `+"\xef\xbb\xbf"+`'su root' failed`), "", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ProcID != "77" || m.MsgID != "" || m.StructuredData != nil || m.Message != "'su root' failed" {
+		t.Errorf("unexpected message %#v", m)
+	}
+	record := m.Record("message")
+	if record["facility"] != "auth" || record["severity"] != "crit" || record["app_name"] != "su" || record["message"] != "'su root' failed" {
+		t.Errorf("unexpected record %#v", record)
+	}
+	if _, ok := record["msgid"]; ok {
+		t.Errorf("nil value must not be in record %#v", record)
+	}
+}
+
+func TestParseSyslogInvalid(t *testing.T) {
+	for _, line := range []string{
+		"no priority",
+		"<999>Oct 11 22:14:15 host app: msg",
+		"<34>1 2003-10-11T22:14:15Z host",
+		`<34>1 2003-10-11T22:14:15Z host app - - [id key="value] msg`,
+	} {
+		if m, err := hydra.ParseSyslog([]byte(line), "", time.Now()); err == nil {
+			t.Errorf("%s must be invalid. got %#v", line, m)
+		}
+	}
+	if _, err := hydra.ParseSyslog([]byte("<34>Oct 11 22:14:15 host app: msg"), hydra.SyslogRFC5424, time.Now()); err == nil {
+		t.Error("RFC3164 message must be invalid for rfc5424 format")
+	}
+}
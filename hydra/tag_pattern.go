@@ -0,0 +1,129 @@
+package hydra
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TagPattern matches tags by fluentd's glob semantics.
+// "*" matches a part of a tag, "**" matches zero or more parts, "{a,b}" matches a or b.
+// Multiple patterns can be separated by spaces.
+type TagPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func NewTagPattern(pattern string) (*TagPattern, error) {
+	fields := strings.Fields(pattern)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty tag pattern")
+	}
+	exprs := make([]string, len(fields))
+	for i, f := range fields {
+		expr, err := tagPatternToRegexp(f)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = "(?:" + expr + ")"
+	}
+	re, err := regexp.Compile(`\A(?:` + strings.Join(exprs, "|") + `)\z`)
+	if err != nil {
+		return nil, err
+	}
+	return &TagPattern{pattern: pattern, re: re}, nil
+}
+
+// Match returns true if the tag matches the pattern. A nil pattern matches any tags.
+func (p *TagPattern) Match(tag string) bool {
+	if p == nil {
+		return true
+	}
+	return p.re.MatchString(tag)
+}
+
+func (p *TagPattern) String() string {
+	if p == nil {
+		return "**"
+	}
+	return p.pattern
+}
+
+func (p *TagPattern) UnmarshalText(text []byte) error {
+	tp, err := NewTagPattern(string(text))
+	if err != nil {
+		return err
+	}
+	*p = *tp
+	return nil
+}
+
+func tagPatternToRegexp(pattern string) (string, error) {
+	var b bytes.Buffer
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				switch {
+				case i+1 < len(pattern) && pattern[i+1] == '.':
+					// "**." matches zero or more parts followed by a dot
+					b.WriteString(`(?:.*\.)?`)
+					i++
+				case i+1 == len(pattern) && bytes.HasSuffix(b.Bytes(), []byte(`\.`)):
+					// ".**" at the end matches zero or more parts
+					b.Truncate(b.Len() - len(`\.`))
+					b.WriteString(`(?:\..*)?`)
+				default:
+					b.WriteString(`.*`)
+				}
+			} else {
+				b.WriteString(`[^.]*`)
+			}
+		case '{':
+			alts, end := splitBraces(pattern[i:])
+			if end < 0 {
+				return "", fmt.Errorf("unterminated { in tag pattern %s", pattern)
+			}
+			for j, alt := range alts {
+				expr, err := tagPatternToRegexp(alt)
+				if err != nil {
+					return "", err
+				}
+				alts[j] = expr
+			}
+			b.WriteString("(?:" + strings.Join(alts, "|") + ")")
+			i += end
+		case '}':
+			return "", fmt.Errorf("unexpected } in tag pattern %s", pattern)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// splitBraces splits "{a,{b,c}}..." into alternatives ("a", "{b,c}") at the top level,
+// and returns the index of the matching '}' (-1 if not terminated).
+func splitBraces(s string) ([]string, int) {
+	alts := make([]string, 0, 2)
+	depth, start := 0, 1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return append(alts, s[start:i]), i
+			}
+		case ',':
+			if depth == 1 {
+				alts = append(alts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return nil, -1
+}
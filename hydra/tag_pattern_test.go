@@ -0,0 +1,54 @@
+package hydra_test
+
+import (
+	"testing"
+
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+var tagPatternTests = []struct {
+	pattern string
+	match   []string
+	unmatch []string
+}{
+	{"a.b", []string{"a.b"}, []string{"a", "a.b.c", "axb"}},
+	{"a.*", []string{"a.b", "a.c"}, []string{"a", "a.b.c", "b.a"}},
+	{"a.**", []string{"a", "a.b", "a.b.c"}, []string{"ab", "b.a"}},
+	{"**.b", []string{"b", "a.b", "x.y.b"}, []string{"a.bc", "b.a"}},
+	{"a.**.c", []string{"a.c", "a.b.c", "a.b.x.c"}, []string{"a.b", "c"}},
+	{"**", []string{"a", "a.b.c"}, []string{}},
+	{"{access,audit}.*", []string{"access.nginx", "audit.app"}, []string{"error.nginx", "access"}},
+	{"app.{a.*,b}", []string{"app.a.x", "app.b"}, []string{"app.a", "app.c"}},
+	{"access.** audit", []string{"access.x", "audit"}, []string{"audit.x"}},
+	{"{a,{b,c}}.x", []string{"a.x", "b.x", "c.x"}, []string{"x", "{b.x", "c}.x"}},
+	{"{a,b.{c,d}}", []string{"a", "b.c", "b.d"}, []string{"b", "a.c"}},
+}
+
+func TestTagPattern(t *testing.T) {
+	for _, tc := range tagPatternTests {
+		p, err := hydra.NewTagPattern(tc.pattern)
+		if err != nil {
+			t.Errorf("%s: %s", tc.pattern, err)
+			continue
+		}
+		for _, tag := range tc.match {
+			if !p.Match(tag) {
+				t.Errorf("%s must match %s", tc.pattern, tag)
+			}
+		}
+		for _, tag := range tc.unmatch {
+			if p.Match(tag) {
+				t.Errorf("%s must not match %s", tc.pattern, tag)
+			}
+		}
+	}
+	for _, pattern := range []string{"{a,b", "{a,{b,c}", "a}", "{a,b}}"} {
+		if _, err := hydra.NewTagPattern(pattern); err == nil {
+			t.Errorf("unbalanced braces %s must be an error", pattern)
+		}
+	}
+	var p *hydra.TagPattern
+	if !p.Match("any.tag") {
+		t.Error("nil pattern must match any tags")
+	}
+}
@@ -0,0 +1,83 @@
+package hydra
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// ConfigTLS is TLS settings for out_forward and in_forward.
+type ConfigTLS struct {
+	TLS                bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no valid certificates in " + filename)
+	}
+	return pool, nil
+}
+
+// ClientTLSConfig returns *tls.Config for connecting to the server.
+func (ct *ConfigTLS) ClientTLSConfig(serverName string) (*tls.Config, error) {
+	if !ct.TLS {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: ct.InsecureSkipVerify,
+	}
+	if ct.CAFile != "" {
+		pool, err := loadCertPool(ct.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if ct.CertFile != "" || ct.KeyFile != "" {
+		// client certificate
+		cert, err := tls.LoadX509KeyPair(ct.CertFile, ct.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// ServerTLSConfig returns *tls.Config for listening.
+// When verifyClient is true, client certificates are required and verified by CAFile.
+func (ct *ConfigTLS) ServerTLSConfig(verifyClient bool) (*tls.Config, error) {
+	if !ct.TLS {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(ct.CertFile, ct.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if verifyClient {
+		if ct.CAFile == "" {
+			return nil, errors.New("CAFile is required to verify client certificates")
+		}
+		pool, err := loadCertPool(ct.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,232 @@
+package hydra_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/fluent-agent-hydra/fluent"
+	"github.com/fujiwara/fluent-agent-hydra/hydra"
+)
+
+type testCerts struct {
+	CAFile, ServerCertFile, ServerKeyFile, ClientCertFile, ClientKeyFile string
+}
+
+func writePEM(t *testing.T, filename, typ string, b []byte) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pem.Encode(f, &pem.Block{Type: typ, Bytes: b})
+}
+
+func generateCert(t *testing.T, dir, name string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return cert, key, certFile, keyFile
+}
+
+func generateTestCerts(t *testing.T, dir string) *testCerts {
+	now := time.Now()
+	ca, caKey, caFile, _ := generateCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "hydra test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	_, _, serverCert, serverKey := generateCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	_, _, clientCert, clientKey := generateCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "hydra client"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	return &testCerts{caFile, serverCert, serverKey, clientCert, clientKey}
+}
+
+func runTLSReceiver(t *testing.T, certs *testCerts, verifyClient bool) (*hydra.Context, *hydra.InForward) {
+	config := &hydra.ConfigReceiver{
+		Host:              "127.0.0.1",
+		Port:              0,
+		MaxBufferMessages: 1000,
+		ConfigTLS: hydra.ConfigTLS{
+			TLS:      true,
+			CAFile:   certs.CAFile,
+			CertFile: certs.ServerCertFile,
+			KeyFile:  certs.ServerKeyFile,
+		},
+		VerifyClientCert: verifyClient,
+	}
+	c := hydra.NewContext()
+	inForward, err := hydra.NewInForward(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(inForward)
+	return c, inForward
+}
+
+func countMessages(ch chan *fluent.FluentRecordSet, wait time.Duration) int {
+	n := 0
+	for {
+		select {
+		case rs := <-ch:
+			n += len(rs.Records)
+		case <-time.After(wait):
+			return n
+		}
+	}
+}
+
+func TestTLSForward(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	certs := generateTestCerts(t, tmpdir)
+
+	rc, inForward := runTLSReceiver(t, certs, true)
+
+	configServer := newConfigServer(inForward.Addr.String())
+	configServer.ConfigTLS = hydra.ConfigTLS{
+		TLS:      true,
+		CAFile:   certs.CAFile,
+		CertFile: certs.ClientCertFile,
+		KeyFile:  certs.ClientKeyFile,
+	}
+	c := hydra.NewContext()
+	outForward, err := hydra.NewOutForward([]*hydra.ConfigServer{configServer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RunProcess(outForward)
+	c.MessageCh <- prepareRecordSet()
+
+	if n := countMessages(rc.MessageCh, time.Second); n != len(TestMessageLines) {
+		t.Errorf("arrived messages %d expected %d", n, len(TestMessageLines))
+	}
+	c.Shutdown()
+}
+
+func TestTLSHandshakeError(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	certs := generateTestCerts(t, tmpdir)
+
+	_, inForward := runTLSReceiver(t, certs, false)
+
+	// server certificate is not trusted without CAFile
+	configServer := newConfigServer(inForward.Addr.String())
+	configServer.ConfigTLS = hydra.ConfigTLS{TLS: true}
+	tlsConfig, err := configServer.ClientTLSConfig(configServer.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := fluent.New(fluent.Config{
+		Server:    configServer.Address(),
+		TLSConfig: tlsConfig,
+	})
+	if err == nil {
+		t.Error("handshake must be failed")
+	}
+	if logger.Alive() {
+		t.Error("logger must not be alive")
+	}
+	if e := logger.LastErrorString(); !strings.Contains(e, "TLS handshake failed") {
+		t.Errorf("unexpected last error %s", e)
+	}
+
+	// skip verify
+	configServer.InsecureSkipVerify = true
+	tlsConfig, _ = configServer.ClientTLSConfig(configServer.Host)
+	logger, err = fluent.New(fluent.Config{
+		Server:    configServer.Address(),
+		TLSConfig: tlsConfig,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	logger.Close()
+}
+
+func TestTLSClientCertRequired(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir(os.TempDir(), "hydra-test")
+	defer os.RemoveAll(tmpdir)
+	certs := generateTestCerts(t, tmpdir)
+
+	rc, inForward := runTLSReceiver(t, certs, true)
+
+	// without client certificate
+	configServer := newConfigServer(inForward.Addr.String())
+	configServer.ConfigTLS = hydra.ConfigTLS{
+		TLS:    true,
+		CAFile: certs.CAFile,
+	}
+	tlsConfig, _ := configServer.ClientTLSConfig(configServer.Host)
+	logger, err := fluent.New(fluent.Config{
+		Server:    configServer.Address(),
+		TLSConfig: tlsConfig,
+	})
+	if err == nil {
+		packed, _ := prepareRecordSet().PackAsPackedForward()
+		logger.Send(packed)
+		defer logger.Close()
+	}
+	if n := countMessages(rc.MessageCh, time.Second); n != 0 {
+		t.Errorf("messages from a client without certificate must be rejected. arrived %d", n)
+	}
+	stats := &hydra.Stats{}
+RECEIVE:
+	for {
+		select {
+		case s := <-rc.MonitorCh:
+			if s, ok := s.(*hydra.ReceiverStat); ok {
+				s.ApplyTo(stats)
+			}
+		default:
+			break RECEIVE
+		}
+	}
+	if stats.Receiver == nil || stats.Receiver.TLSHandshakeFailures != 1 {
+		t.Errorf("a TLS handshake failure must be counted %#v", stats.Receiver)
+	}
+}